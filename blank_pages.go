@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	_ "golang.org/x/image/tiff"
+)
+
+// Pixels darker than this luminance (0-255) count as ink
+const inkLuminance = 128
+
+// Removes pages whose ink coverage in percent is below the threshold.
+// Returns the remaining pages and the number of removed pages.
+func removeBlankPages(pages []io.ReadSeeker, threshold float64) ([]io.ReadSeeker, int, error) {
+	remaining := []io.ReadSeeker{}
+	for i, page := range pages {
+		coverage, err := inkCoverage(page)
+		if err != nil {
+			return nil, 0, err
+		}
+		fmt.Printf("Page %d has %.3f%% ink coverage\n", i+1, coverage)
+		if coverage >= threshold {
+			remaining = append(remaining, page)
+		}
+	}
+	return remaining, len(pages) - len(remaining), nil
+}
+
+// Returns the percentage of dark pixels in the images of a single page pdf.
+// Scanned pages consist of a single image, pages without images are never blank.
+func inkCoverage(page io.ReadSeeker) (float64, error) {
+	if _, err := page.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	pageImages, err := api.ExtractImagesRaw(page, nil, model.NewDefaultConfiguration())
+	if _, seekErr := page.Seek(0, io.SeekStart); seekErr != nil {
+		return 0, seekErr
+	}
+	if err != nil {
+		fmt.Printf("failed to extract images: %s\n", err.Error())
+		return 0, err
+	}
+	var dark, total int
+	for _, images := range pageImages {
+		for _, pageImage := range images {
+			decoded, _, err := image.Decode(pageImage)
+			if err != nil {
+				// Images that cannot be analysed are treated as content
+				fmt.Printf("failed to decode %s image: %s\n", pageImage.FileType, err.Error())
+				return 100, nil
+			}
+			bounds := decoded.Bounds()
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					r, g, b, _ := decoded.At(x, y).RGBA()
+					// ITU-R 601 luma of the 16 bit channels, scaled to 8 bit
+					luminance := (19595*r + 38470*g + 7471*b + 1<<15) >> 24
+					if luminance < inkLuminance {
+						dark++
+					}
+					total++
+				}
+			}
+		}
+	}
+	if total == 0 {
+		return 100, nil
+	}
+	return float64(dark) * 100 / float64(total), nil
+}
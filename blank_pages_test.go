@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"testing"
+)
+
+// Single page pdf of a 100x100 page with the given gray background and number of black pixels.
+func testPage(t *testing.T, background uint8, darkPixels int) io.ReadSeeker {
+	t.Helper()
+	page := image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := range page.Pix {
+		page.Pix[i] = background
+	}
+	for i := 0; i < darkPixels; i++ {
+		page.Pix[i*7%len(page.Pix)] = 0
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, page); err != nil {
+		t.Fatal(err)
+	}
+	pdf, err := imageToPdf(&encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pdf
+}
+
+func TestInkCoverage(t *testing.T) {
+	tests := []struct {
+		name       string
+		background uint8
+		darkPixels int
+		want       float64
+	}{
+		{name: "white", background: 255, want: 0},
+		{name: "near white", background: 200, want: 0},
+		{name: "light gray is no ink", background: inkLuminance, want: 0},
+		{name: "dark gray is ink", background: inkLuminance - 1, want: 100},
+		{name: "speckles", background: 255, darkPixels: 30, want: 0.3},
+		{name: "content", background: 255, darkPixels: 800, want: 8},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			coverage, err := inkCoverage(testPage(t, test.background, test.darkPixels))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(coverage-test.want) > 0.001 {
+				t.Errorf("coverage = %.3f%%, want %.3f%%", coverage, test.want)
+			}
+		})
+	}
+	// Pages without images are text or vector content, never blank
+	text, err := textToPdf(bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coverage, err := inkCoverage(text); err != nil || coverage != 100 {
+		t.Errorf("page without images: coverage = %.3f%%, %v, want 100%%", coverage, err)
+	}
+}
+
+func TestRemoveBlankPages(t *testing.T) {
+	white := func(t *testing.T) io.ReadSeeker { return testPage(t, 255, 0) }
+	nearWhite := func(t *testing.T) io.ReadSeeker { return testPage(t, 220, 20) }
+	// 0.5% coverage, exactly at the threshold
+	threshold := func(t *testing.T) io.ReadSeeker { return testPage(t, 255, 50) }
+	content := func(t *testing.T) io.ReadSeeker { return testPage(t, 255, 800) }
+	tests := []struct {
+		name        string
+		pages       []func(t *testing.T) io.ReadSeeker
+		threshold   float64
+		wantKept    []int
+		wantRemoved int
+	}{
+		{name: "no pages", threshold: 0.5, wantKept: []int{}},
+		{name: "keeps content", pages: []func(t *testing.T) io.ReadSeeker{content, content}, threshold: 0.5, wantKept: []int{0, 1}},
+		{name: "removes blank backs", pages: []func(t *testing.T) io.ReadSeeker{content, white, content, nearWhite}, threshold: 0.5, wantKept: []int{0, 2}, wantRemoved: 2},
+		{name: "keeps pages at the threshold", pages: []func(t *testing.T) io.ReadSeeker{threshold, white}, threshold: 0.5, wantKept: []int{0}, wantRemoved: 1},
+		{name: "all blank", pages: []func(t *testing.T) io.ReadSeeker{white, nearWhite, white}, threshold: 0.5, wantKept: []int{}, wantRemoved: 3},
+		{name: "zero threshold keeps everything", pages: []func(t *testing.T) io.ReadSeeker{white, content}, threshold: 0, wantKept: []int{0, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pages := []io.ReadSeeker{}
+			for _, page := range test.pages {
+				pages = append(pages, page(t))
+			}
+			remaining, removed, err := removeBlankPages(pages, test.threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if removed != test.wantRemoved {
+				t.Errorf("removed = %d, want %d", removed, test.wantRemoved)
+			}
+			if len(remaining) != len(test.wantKept) {
+				t.Fatalf("%d pages remaining, want %d", len(remaining), len(test.wantKept))
+			}
+			for i, index := range test.wantKept {
+				if remaining[i] != pages[index] {
+					t.Errorf("page %d is not page %d", i, index)
+				}
+				// The kept pages are read again when they are merged
+				if position, _ := remaining[i].Seek(0, io.SeekCurrent); position != 0 {
+					t.Errorf("page %d was left at offset %d", i, position)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Configuration read from the yaml file in CONFIG_FILE, see config.example.yaml.
+type config struct {
+	Telegram    telegramConfig  `yaml:"telegram"`
+	Scanners    []scannerConfig `yaml:"scanners"`
+	Targets     targetsConfig   `yaml:"targets"`
+	Defaults    functionConfig  `yaml:"defaults"`
+	Ocr         ocrConfig       `yaml:"ocr"`
+	LibreOffice string          `yaml:"libreOffice"`
+}
+
+type telegramConfig struct {
+	Token        string  `yaml:"token"`
+	AllowedUsers []int64 `yaml:"allowedUsers"`
+}
+
+type scannerConfig struct {
+	Name     string `yaml:"name"`
+	Backend  string `yaml:"backend"`
+	Endpoint string `yaml:"endpoint"`
+	Device   string `yaml:"device"`
+	// custom scan areas like Receipt=80x200 or Photo=100x150+10+10
+	Areas []string `yaml:"areas"`
+	// percentage of dark pixels below which a feeder page counts as blank
+	BlankPageThreshold *float64         `yaml:"blankPageThreshold"`
+	Functions          []functionConfig `yaml:"functions"`
+}
+
+type functionConfig struct {
+	Source           string   `yaml:"source"`
+	Mode             string   `yaml:"mode"`
+	Target           string   `yaml:"target"`
+	Format           string   `yaml:"format"`
+	Resolution       int      `yaml:"resolution"`
+	Filters          []string `yaml:"filters"`
+	Ocr              *bool    `yaml:"ocr"`
+	RemoveBlankPages *bool    `yaml:"removeBlankPages"`
+}
+
+type targetsConfig struct {
+	Telegram  targetConfig `yaml:"telegram"`
+	Paperless targetConfig `yaml:"paperless"`
+	Printer   targetConfig `yaml:"printer"`
+}
+
+type targetConfig struct {
+	// name shown on the target button, the target name if empty
+	Label    string `yaml:"label"`
+	Endpoint string `yaml:"endpoint"`
+	Token    string `yaml:"token"`
+}
+
+type ocrConfig struct {
+	Binary   string `yaml:"binary"`
+	Language string `yaml:"language"`
+}
+
+const defaultConfigFile = "config.yaml"
+
+const defaultBlankPageThreshold = 0.5
+
+// Functions offered if the scanner is configured through environment variables
+var defaultFunctions = []functionConfig{
+	{Source: "ADF", Mode: "Color", Target: "telegram"},
+	{Source: "Flatbed", Mode: "Color", Target: "telegram"},
+	{Source: "ADF", Mode: "Gray", Target: "telegram"},
+	{Source: "Flatbed", Mode: "Gray", Target: "telegram"},
+}
+
+// Functions added to the defaults if paperless is configured through environment variables
+var defaultPaperlessFunctions = []functionConfig{
+	{Source: "ADF", Mode: "Gray", Target: "paperless", RemoveBlankPages: newTrue()},
+	{Source: "Flatbed", Mode: "Gray", Target: "paperless"},
+	{Source: "ADF", Mode: "Color", Target: "paperless", RemoveBlankPages: newTrue()},
+	{Source: "Flatbed", Mode: "Color", Target: "paperless"},
+}
+
+// Functions added to the defaults if a printer is configured through environment variables
+var defaultPrinterFunctions = []functionConfig{
+	{Source: "ADF", Mode: "Gray", Target: "printer"},
+	{Source: "Flatbed", Mode: "Gray", Target: "printer"},
+	{Source: "ADF", Mode: "Color", Target: "printer"},
+	{Source: "Flatbed", Mode: "Color", Target: "printer"},
+}
+
+func newTrue() *bool {
+	value := true
+	return &value
+}
+
+// Loads the config file. Without CONFIG_FILE and config.yaml the configuration is read
+// from environment variables. Secrets in environment variables override the file.
+func loadConfig(path string) (*config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile
+	}
+	content, err := os.ReadFile(path)
+	var config *config
+	switch {
+	case err == nil:
+		fmt.Printf("Reading config file %s\n", path)
+		config, err = parseConfig(content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && !explicit:
+		fmt.Println("No config file, reading the configuration from the environment")
+		config, err = environmentConfig()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("cannot read config file %s: %w", path, err)
+	}
+	if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+		config.Telegram.Token = token
+	}
+	if token := os.Getenv("PAPERLESS_TOKEN"); token != "" {
+		config.Targets.Paperless.Token = token
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return config, nil
+}
+
+func parseConfig(content []byte) (*config, error) {
+	var config config
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Builds the configuration from the environment variables used before the config file existed.
+func environmentConfig() (*config, error) {
+	config := &config{
+		Telegram: telegramConfig{
+			Token: os.Getenv("TELEGRAM_BOT_TOKEN"),
+		},
+		Targets: targetsConfig{
+			Paperless: targetConfig{
+				Endpoint: os.Getenv("PAPERLESS_ENDPOINT"),
+				Token:    os.Getenv("PAPERLESS_TOKEN"),
+			},
+			Printer: targetConfig{
+				Endpoint: os.Getenv("PRINTER_ENDPOINT"),
+			},
+		},
+		Ocr: ocrConfig{
+			Binary:   os.Getenv("OCR_BINARY"),
+			Language: os.Getenv("OCR_LANGUAGE"),
+		},
+		LibreOffice: os.Getenv("LIBREOFFICE_BINARY"),
+	}
+	for _, id := range strings.Split(os.Getenv("ALLOWED_TELEGRAM_USERS"), ";") {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err == nil {
+			config.Telegram.AllowedUsers = append(config.Telegram.AllowedUsers, n)
+		} else {
+			fmt.Printf("Failed to parse user %s (error: %s)\n", id, err)
+		}
+	}
+	scanner := scannerConfig{
+		Backend:   os.Getenv("SCANNER_BACKEND"),
+		Endpoint:  os.Getenv("SCANNER_ENDPOINT"),
+		Device:    os.Getenv("SCANNER_DEVICE_ID"),
+		Functions: defaultFunctions,
+	}
+	if areas := os.Getenv("SCANNER_AREAS"); areas != "" {
+		scanner.Areas = strings.Split(areas, ";")
+	}
+	if threshold := os.Getenv("BLANK_PAGE_THRESHOLD"); threshold != "" {
+		value, err := strconv.ParseFloat(threshold, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BLANK_PAGE_THRESHOLD %s: %w", threshold, err)
+		}
+		scanner.BlankPageThreshold = &value
+	}
+	if config.Ocr.Binary != "" || config.Ocr.Language != "" {
+		// Configuring ocr enables it for the scans sent to telegram
+		config.Defaults.Ocr = newTrue()
+	}
+	if config.Targets.Paperless.Endpoint != "" {
+		scanner.Functions = append(slices.Clone(scanner.Functions), defaultPaperlessFunctions...)
+	}
+	if config.Targets.Printer.Endpoint != "" {
+		scanner.Functions = append(slices.Clone(scanner.Functions), defaultPrinterFunctions...)
+	}
+	config.Scanners = []scannerConfig{scanner}
+	return config, nil
+}
+
+// Checks the whole configuration and returns all problems at once.
+func (config config) validate() error {
+	errs := []error{}
+	if config.Telegram.Token == "" {
+		errs = append(errs, fmt.Errorf("telegram.token: missing bot token, set it in the file or in TELEGRAM_BOT_TOKEN"))
+	}
+	if len(config.Telegram.AllowedUsers) == 0 {
+		errs = append(errs, fmt.Errorf("telegram.allowedUsers: no users allowed, nobody could use the bot"))
+	}
+	if len(config.Scanners) == 0 {
+		errs = append(errs, fmt.Errorf("scanners: no scanner configured"))
+	}
+	if _, err := config.Defaults.toScannerFunction(functionConfig{}); err != nil && !errors.Is(err, errIncompleteFunction) {
+		errs = append(errs, fmt.Errorf("defaults: %w", err))
+	}
+	names := []string{}
+	for i, scanner := range config.Scanners {
+		prefix := fmt.Sprintf("scanners[%d]", i)
+		if slices.Contains(names, scanner.displayName()) {
+			errs = append(errs, fmt.Errorf("%s.name: %q is used by another scanner", prefix, scanner.displayName()))
+		}
+		names = append(names, scanner.displayName())
+		if _, err := newScannerBackend(scanner.Backend, scanner.Endpoint, scanner.Device); err != nil {
+			errs = append(errs, fmt.Errorf("%s.backend: %w", prefix, err))
+		}
+		if _, err := parseScannerAreas(strings.Join(scanner.Areas, ";")); err != nil {
+			errs = append(errs, fmt.Errorf("%s.areas: %w", prefix, err))
+		}
+		if scanner.BlankPageThreshold != nil && (*scanner.BlankPageThreshold < 0 || *scanner.BlankPageThreshold > 100) {
+			errs = append(errs, fmt.Errorf("%s.blankPageThreshold: %g is not a percentage", prefix, *scanner.BlankPageThreshold))
+		}
+		if len(scanner.Functions) == 0 {
+			errs = append(errs, fmt.Errorf("%s.functions: no functions configured", prefix))
+		}
+		for j, function := range scanner.Functions {
+			scannerFunction, err := function.toScannerFunction(config.Defaults)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.functions[%d]: %w", prefix, j, err))
+				continue
+			}
+			if scannerFunction.target == paperless && config.Targets.Paperless.Endpoint == "" {
+				errs = append(errs, fmt.Errorf("%s.functions[%d]: target paperless needs targets.paperless.endpoint", prefix, j))
+			}
+			if scannerFunction.target == printerTarget && config.Targets.Printer.Endpoint == "" {
+				errs = append(errs, fmt.Errorf("%s.functions[%d]: target printer needs targets.printer.endpoint", prefix, j))
+			}
+		}
+	}
+	if config.Targets.Printer.Endpoint != "" {
+		if _, err := newPrinter(config.Targets.Printer.Endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("targets.printer.endpoint: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var errIncompleteFunction = errors.New("source, mode and target are required")
+
+// Converts a configured function, using the defaults for the fields it does not set.
+func (function functionConfig) toScannerFunction(defaults functionConfig) (ScannerFunction, error) {
+	if function.Format == "" {
+		function.Format = defaults.Format
+	}
+	if function.Resolution == 0 {
+		function.Resolution = defaults.Resolution
+	}
+	if function.Filters == nil {
+		function.Filters = defaults.Filters
+	}
+	if function.Ocr == nil {
+		function.Ocr = defaults.Ocr
+	}
+	if function.RemoveBlankPages == nil {
+		function.RemoveBlankPages = defaults.RemoveBlankPages
+	}
+	result := ScannerFunction{
+		source:     ScannerSource(function.Source),
+		mode:       ScannerMode(function.Mode),
+		target:     ScannerTarget(function.Target),
+		format:     ScannerFormat(function.Format),
+		resolution: ScannerResolution(function.Resolution),
+	}
+	// Searchable scans are opt-in, ocrmypdf is not installed everywhere
+	result.ocr = function.Ocr != nil && *function.Ocr
+	result.removeBlankPages = function.RemoveBlankPages != nil && *function.RemoveBlankPages
+	if _, ok := scannerFormat[result.format]; !ok && result.format != "" {
+		return result, fmt.Errorf("unknown format %q, expected one of %s", function.Format, configValues(scannerFormat))
+	}
+	if function.Resolution < 0 {
+		return result, fmt.Errorf("invalid resolution %d", function.Resolution)
+	}
+	for _, name := range function.Filters {
+		filter := ScannerFilter(name)
+		if _, ok := scannerFilter[filter]; !ok {
+			return result, fmt.Errorf("unknown filter %q, expected one of %s", name, configValues(scannerFilter))
+		}
+		result.filters = append(result.filters, filter)
+	}
+	if function.Source == "" || function.Mode == "" || function.Target == "" {
+		return result, errIncompleteFunction
+	}
+	if _, ok := scannerSource[result.source]; !ok {
+		return result, fmt.Errorf("unknown source %q, expected one of %s", function.Source, configValues(scannerSource))
+	}
+	if _, ok := scannerMode[result.mode]; !ok {
+		return result, fmt.Errorf("unknown mode %q, expected one of %s", function.Mode, configValues(scannerMode))
+	}
+	if _, ok := scannerTarget[result.target]; !ok {
+		return result, fmt.Errorf("unknown target %q, expected one of %s", function.Target, configValues(scannerTarget))
+	}
+	return result, nil
+}
+
+// Returns the valid values of an enum map for error messages.
+func configValues[T ~string](values map[T]string) string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, string(key))
+	}
+	slices.Sort(keys)
+	return strings.Join(keys, ", ")
+}
+
+// Returns the functions of a scanner. The configuration has to be validated before.
+func (config config) scannerFunctions(scanner scannerConfig) []ScannerFunction {
+	functions := []ScannerFunction{}
+	for _, function := range scanner.Functions {
+		scannerFunction, _ := function.toScannerFunction(config.Defaults)
+		functions = append(functions, scannerFunction)
+	}
+	return functions
+}
+
+// Returns the name shown in the chat, falling back to the device or endpoint.
+func (scanner scannerConfig) displayName() string {
+	switch {
+	case scanner.Name != "":
+		return scanner.Name
+	case scanner.Device != "":
+		return scanner.Device
+	case scanner.Endpoint != "":
+		return scanner.Endpoint
+	}
+	return "Scanner"
+}
+
+func (scanner scannerConfig) blankPageThreshold() float64 {
+	if scanner.BlankPageThreshold == nil {
+		return defaultBlankPageThreshold
+	}
+	return *scanner.BlankPageThreshold
+}
+
+// Replaces the target names shown in the chat with the configured labels.
+func (targets targetsConfig) applyLabels() {
+	for target, targetConfig := range map[ScannerTarget]targetConfig{
+		telegram:      targets.Telegram,
+		paperless:     targets.Paperless,
+		printerTarget: targets.Printer,
+	} {
+		if targetConfig.Label != "" {
+			scannerTarget[target] = targetConfig.Label
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFunctionOcrIsOptIn(t *testing.T) {
+	enabled := true
+	tests := []struct {
+		name     string
+		function functionConfig
+		defaults functionConfig
+		want     bool
+	}{
+		{name: "telegram without setting", function: functionConfig{Source: "ADF", Mode: "Color", Target: "telegram"}},
+		{name: "enabled on the function", function: functionConfig{Source: "ADF", Mode: "Color", Target: "telegram", Ocr: &enabled}, want: true},
+		{name: "enabled in the defaults", function: functionConfig{Source: "ADF", Mode: "Color", Target: "telegram"}, defaults: functionConfig{Ocr: &enabled}, want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			function, err := test.function.toScannerFunction(test.defaults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if function.ocr != test.want {
+				t.Errorf("ocr = %t, want %t", function.ocr, test.want)
+			}
+		})
+	}
+}
+
+func TestEnvironmentConfigOcr(t *testing.T) {
+	t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+	t.Setenv("OCR_LANGUAGE", "")
+	t.Setenv("OCR_BINARY", "")
+	config, err := environmentConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Defaults.Ocr != nil {
+		t.Errorf("ocr enabled without ocr settings")
+	}
+	t.Setenv("OCR_LANGUAGE", "deu")
+	config, err = environmentConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Defaults.Ocr == nil || !*config.Defaults.Ocr {
+		t.Errorf("ocr not enabled by OCR_LANGUAGE")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var imageMimeTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/tiff",
+	"image/webp",
+}
+
+var officeMimeTypes = []string{
+	"application/msword",
+	"application/rtf",
+	"application/vnd.ms-excel",
+	"application/vnd.ms-powerpoint",
+	"application/vnd.oasis.opendocument.presentation",
+	"application/vnd.oasis.opendocument.spreadsheet",
+	"application/vnd.oasis.opendocument.text",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"text/csv",
+	"text/rtf",
+}
+
+// File extensions for documents that arrive without a file name
+var mimeTypeExtension = map[string]string{
+	"application/pdf":                                 ".pdf",
+	"application/msword":                              ".doc",
+	"application/rtf":                                 ".rtf",
+	"application/vnd.ms-excel":                        ".xls",
+	"application/vnd.ms-powerpoint":                   ".ppt",
+	"application/vnd.oasis.opendocument.presentation": ".odp",
+	"application/vnd.oasis.opendocument.spreadsheet":  ".ods",
+	"application/vnd.oasis.opendocument.text":         ".odt",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": ".pptx",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         ".xlsx",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   ".docx",
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/tiff": ".tif",
+	"image/webp": ".webp",
+	"text/csv":   ".csv",
+	"text/plain": ".txt",
+	"text/rtf":   ".rtf",
+}
+
+// Longest time LibreOffice may take for a document before it is stopped
+const officeTimeout = time.Minute * 2
+
+type converter struct {
+	// LibreOffice binary used for office documents, empty if it is not installed
+	officePath string
+	// stops LibreOffice, which hangs on broken documents or a locked profile
+	officeTimeout time.Duration
+}
+
+// Creates a converter. officeBinary is looked up in PATH, a missing binary disables office conversion.
+func newConverter(officeBinary string) *converter {
+	officePath, err := exec.LookPath(officeBinary)
+	if err != nil {
+		fmt.Printf("LibreOffice not found (%s), office documents cannot be converted\n", officeBinary)
+		officePath = ""
+	}
+	return &converter{
+		officePath:    officePath,
+		officeTimeout: officeTimeout,
+	}
+}
+
+// Converts a received file to pdf and returns it with a pdf file name.
+func (converter converter) toPdf(file io.Reader, fileName string, mimeType string) (io.ReadSeeker, string, error) {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	fileName = documentFileName(fileName, mimeType)
+	pdfName := strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".pdf"
+	switch {
+	case mimeType == "application/pdf":
+		return readerToReadSeeker(file), fileName, nil
+	case slices.Contains(imageMimeTypes, mimeType):
+		pdf, err := imageToPdf(file)
+		return pdf, pdfName, err
+	case mimeType == "text/plain":
+		pdf, err := textToPdf(file)
+		return pdf, pdfName, err
+	case slices.Contains(officeMimeTypes, mimeType):
+		if converter.officePath == "" {
+			return nil, "", fmt.Errorf("cannot convert %s, LibreOffice is not installed", fileName)
+		}
+		pdf, err := converter.officeToPdf(file, fileName)
+		return pdf, pdfName, err
+	}
+	return nil, "", fmt.Errorf("cannot convert %s, file type %s is not supported", fileName, mimeType)
+}
+
+// Returns the file name of a received document. Telegram documents may come without one,
+// a name is generated from the time and the mime type then.
+func documentFileName(fileName string, mimeType string) string {
+	fileName = filepath.Base(fileName)
+	if fileName != "." && fileName != string(filepath.Separator) {
+		return fileName
+	}
+	return fmt.Sprintf("document_%s%s", time.Now().Format("2006-01-02_15-04-05"), mimeTypeExtension[mimeType])
+}
+
+func imageToPdf(file io.Reader) (io.ReadSeeker, error) {
+	// Leave a small border, most printers cannot print borderless
+	imp, err := api.Import("pos:c, scale:0.95", types.POINTS)
+	if err != nil {
+		return nil, err
+	}
+	var pdf bytes.Buffer
+	err = api.ImportImages(nil, &pdf, []io.Reader{file}, imp, model.NewDefaultConfiguration())
+	if err != nil {
+		fmt.Printf("failed to import image: %s\n", err.Error())
+		return nil, err
+	}
+	return bytes.NewReader(pdf.Bytes()), nil
+}
+
+// A4 page layout for plain text in points
+const (
+	textPageWidth    = 595
+	textPageHeight   = 842
+	textMargin       = 50
+	textFontSize     = 10
+	textLeading      = 12
+	textLinesPerPage = (textPageHeight - 2*textMargin) / textLeading
+	// Courier glyphs are 0.6 em wide
+	textCharsPerLine = (textPageWidth - 2*textMargin) * 10 / (6 * textFontSize)
+)
+
+// Renders plain utf-8 text with the Courier standard font into a pdf.
+func textToPdf(file io.Reader) (io.ReadSeeker, error) {
+	pages := [][]string{{}}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.ReplaceAll(strings.TrimRight(scanner.Text(), "\r"), "\t", "    ")
+		for {
+			if len(pages[len(pages)-1]) == textLinesPerPage {
+				pages = append(pages, []string{})
+			}
+			runes := []rune(line)
+			if len(runes) <= textCharsPerLine {
+				pages[len(pages)-1] = append(pages[len(pages)-1], line)
+				break
+			}
+			pages[len(pages)-1] = append(pages[len(pages)-1], string(runes[:textCharsPerLine]))
+			line = string(runes[textCharsPerLine:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	encoder := encoding.ReplaceUnsupported(charmap.Windows1252.NewEncoder())
+	var pdf bytes.Buffer
+	offsets := []int{}
+	writeObject := func(content string) {
+		offsets = append(offsets, pdf.Len())
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", len(offsets), content)
+	}
+
+	pdf.WriteString("%PDF-1.4\n")
+	// Objects 1-3 are catalog, page tree and font, pages and contents follow in pairs
+	kids := []string{}
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 4+2*i))
+	}
+	writeObject("<< /Type /Catalog /Pages 2 0 R >>")
+	writeObject(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	writeObject("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i, lines := range pages {
+		var content strings.Builder
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", textFontSize, textLeading, textMargin, textPageHeight-textMargin-textFontSize)
+		for _, line := range lines {
+			encoded, err := encoder.String(line)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&content, "(%s) Tj T*\n", escapePdfString(encoded))
+		}
+		content.WriteString("ET")
+		writeObject(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", textPageWidth, textPageHeight, 5+2*i))
+		writeObject(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return bytes.NewReader(pdf.Bytes()), nil
+}
+
+func escapePdfString(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`, "\r", `\r`).Replace(value)
+}
+
+// Converts an office document with LibreOffice in headless mode. LibreOffice is stopped after the office timeout.
+func (converter converter) officeToPdf(file io.Reader, fileName string) (io.ReadSeeker, error) {
+	dir, err := os.MkdirTemp("", "telegram-printer-scanner")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, filepath.Base(fileName))
+	inputFile, err := os.Create(input)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(inputFile, file)
+	inputFile.Close()
+	if err != nil {
+		return nil, err
+	}
+	// Use a private profile so conversions do not clash with a running LibreOffice instance
+	ctx, cancel := context.WithTimeout(context.Background(), converter.officeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, converter.officePath, "-env:UserInstallation=file://"+filepath.Join(dir, "profile"), "--headless", "--convert-to", "pdf", "--outdir", dir, input)
+	// soffice.bin may keep the output open after the wrapper script was killed
+	cmd.WaitDelay = time.Second * 10
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("LibreOffice did not convert %s within %s", fileName, converter.officeTimeout)
+	}
+	if err != nil {
+		fmt.Printf("LibreOffice failed: %s\n", string(output))
+		return nil, fmt.Errorf("LibreOffice could not convert %s: %w", fileName, err)
+	}
+	pdf, err := os.ReadFile(strings.TrimSuffix(input, filepath.Ext(input)) + ".pdf")
+	if err != nil {
+		return nil, fmt.Errorf("LibreOffice did not create a pdf for %s: %w", fileName, err)
+	}
+	return bytes.NewReader(pdf), nil
+}
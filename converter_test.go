@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+func TestDocumentFileName(t *testing.T) {
+	tests := []struct {
+		fileName string
+		mimeType string
+		want     string
+	}{
+		{fileName: "letter.docx", mimeType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", want: `^letter\.docx$`},
+		{fileName: "../../etc/passwd", mimeType: "text/plain", want: `^passwd$`},
+		{fileName: "", mimeType: "application/vnd.oasis.opendocument.text", want: `^document_[0-9_-]+\.odt$`},
+		{fileName: "", mimeType: "text/plain", want: `^document_[0-9_-]+\.txt$`},
+		{fileName: "", mimeType: "application/x-unknown", want: `^document_[0-9_-]+$`},
+	}
+	for _, test := range tests {
+		t.Run(test.fileName+" "+test.mimeType, func(t *testing.T) {
+			if name := documentFileName(test.fileName, test.mimeType); !regexp.MustCompile(test.want).MatchString(name) {
+				t.Errorf("documentFileName = %q, want %s", name, test.want)
+			}
+		})
+	}
+}
+
+func TestTextToPdf(t *testing.T) {
+	// Enough lines for two pages
+	text := strings.Repeat("Grüße aus dem Drucker\n", textLinesPerPage+1)
+	pdf, pdfName, err := (&converter{}).toPdf(strings.NewReader(text), "", "text/plain; charset=utf-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^document_[0-9_-]+\.pdf$`).MatchString(pdfName) {
+		t.Errorf("pdf name = %q", pdfName)
+	}
+	pages, err := api.PageCount(pdf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 2 {
+		t.Errorf("page count = %d, want 2", pages)
+	}
+}
+
+// Office documents without a file name used to be written to the temp directory itself.
+func TestOfficeToPdfWithoutFileName(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("needs /bin/sh")
+	}
+	// Fake LibreOffice that copies a fixture pdf named after the input to the output directory
+	dir := t.TempDir()
+	fixture, err := textToPdf(strings.NewReader("converted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtureBytes, err := io.ReadAll(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixturePath := filepath.Join(dir, "fixture.pdf")
+	if err := os.WriteFile(fixturePath, fixtureBytes, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "soffice")
+	err = os.WriteFile(script, []byte(`#!/bin/sh
+for last; do :; done
+while [ "$1" != "--outdir" ]; do shift; done
+input=$(basename "$last")
+cp `+fixturePath+` "$2/${input%.*}.pdf"
+`), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter := &converter{officePath: script, officeTimeout: officeTimeout}
+	pdf, pdfName, err := converter.toPdf(strings.NewReader("odt"), "", "application/vnd.oasis.opendocument.text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^document_[0-9_-]+\.pdf$`).MatchString(pdfName) {
+		t.Errorf("pdf name = %q", pdfName)
+	}
+	if pages, err := api.PageCount(pdf, nil); err != nil || pages != 1 {
+		t.Errorf("page count = %d, %v, want 1", pages, err)
+	}
+}
+
+func TestOfficeToPdfTimeout(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("needs /bin/sh")
+	}
+	// Fake LibreOffice that hangs like it does on a locked profile
+	script := filepath.Join(t.TempDir(), "soffice")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexec sleep 30\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	converter := &converter{officePath: script, officeTimeout: time.Millisecond * 200}
+	started := time.Now()
+	_, _, err := converter.toPdf(strings.NewReader("odt"), "letter.odt", "application/vnd.oasis.opendocument.text")
+	if err == nil || !strings.Contains(err.Error(), "did not convert letter.odt") {
+		t.Errorf("err = %v, want the timeout", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second*5 {
+		t.Errorf("LibreOffice was stopped after %s", elapsed)
+	}
+}
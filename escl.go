@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// Backend for scanners speaking eSCL (Apple AirScan) over http.
+// The endpoint is the eSCL root of the scanner, e.g. http://mfp.local/eSCL.
+type esclBackend struct {
+	endpoint string
+}
+
+func newEsclBackend(endpoint string) *esclBackend {
+	return &esclBackend{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+	}
+}
+
+type esclInputCaps struct {
+	MaxWidth        int      `xml:"MaxWidth"`
+	MaxHeight       int      `xml:"MaxHeight"`
+	ColorModes      []string `xml:"SettingProfiles>SettingProfile>ColorModes>ColorMode"`
+	DocumentFormats []string `xml:"SettingProfiles>SettingProfile>DocumentFormats>DocumentFormat"`
+	Resolutions     []int    `xml:"SettingProfiles>SettingProfile>SupportedResolutions>DiscreteResolutions>DiscreteResolution>XResolution"`
+}
+
+type esclRange struct {
+	Min    int `xml:"Min"`
+	Max    int `xml:"Max"`
+	Normal int `xml:"Normal"`
+}
+
+type esclCapabilities struct {
+	MakeAndModel string         `xml:"MakeAndModel"`
+	Platen       *esclInputCaps `xml:"Platen>PlatenInputCaps"`
+	AdfSimplex   *esclInputCaps `xml:"Adf>AdfSimplexInputCaps"`
+	AdfDuplex    *esclInputCaps `xml:"Adf>AdfDuplexInputCaps"`
+	Brightness   *esclRange     `xml:"BrightnessSupport"`
+	Contrast     *esclRange     `xml:"ContrastSupport"`
+}
+
+// Converts millimeters to the 1/300 inch eSCL geometry is measured in.
+func mmToEscl(mm int) int {
+	return mm * 3000 / 254
+}
+
+func esclToMm(units int) int {
+	return units * 254 / 3000
+}
+
+var esclInputSource = map[ScannerSource]string{
+	flatbed: "Platen",
+	adf:     "Feeder",
+}
+
+var esclColorMode = map[ScannerMode]string{
+	color:   "RGB24",
+	gray:    "Grayscale8",
+	lineart: "BlackAndWhite1",
+}
+
+type esclScanRegion struct {
+	Height             int    `xml:"pwg:Height"`
+	Width              int    `xml:"pwg:Width"`
+	XOffset            int    `xml:"pwg:XOffset"`
+	YOffset            int    `xml:"pwg:YOffset"`
+	ContentRegionUnits string `xml:"pwg:ContentRegionUnits"`
+}
+
+type esclScanSettings struct {
+	XMLName        xml.Name       `xml:"scan:ScanSettings"`
+	ScanNamespace  string         `xml:"xmlns:scan,attr"`
+	PwgNamespace   string         `xml:"xmlns:pwg,attr"`
+	Version        string         `xml:"pwg:Version"`
+	ScanRegion     esclScanRegion `xml:"pwg:ScanRegions>pwg:ScanRegion"`
+	InputSource    string         `xml:"pwg:InputSource"`
+	ColorMode      string         `xml:"scan:ColorMode"`
+	XResolution    int            `xml:"scan:XResolution"`
+	YResolution    int            `xml:"scan:YResolution"`
+	DocumentFormat string         `xml:"pwg:DocumentFormat"`
+	Duplex         bool           `xml:"scan:Duplex"`
+	Brightness     *int           `xml:"scan:Brightness,omitempty"`
+	Contrast       *int           `xml:"scan:Contrast,omitempty"`
+}
+
+// Returns the adjustment scaled to the range the scanner advertises, nil if it has none.
+func esclAdjustment(value int, support *esclRange) *int {
+	if value == 0 || support == nil || support.Max <= support.Min {
+		return nil
+	}
+	scaled := scaleAdjustment(clampAdjustment(value), support.Min, support.Normal, support.Max)
+	return &scaled
+}
+
+func newEsclScanSettings(function ScannerFunction, capabilities *esclCapabilities, caps *esclInputCaps) esclScanSettings {
+	left, top := mmToEscl(function.area.left), mmToEscl(function.area.top)
+	width, height := mmToEscl(215), mmToEscl(297)
+	if function.area.width > 0 && function.area.height > 0 {
+		width, height = mmToEscl(function.area.width), mmToEscl(function.area.height)
+	}
+	if caps.MaxWidth > 0 {
+		width = min(width, caps.MaxWidth-left)
+	}
+	if caps.MaxHeight > 0 {
+		height = min(height, caps.MaxHeight-top)
+	}
+	// Prefer jpeg pages, the pdf is assembled locally
+	format := "image/jpeg"
+	if !slices.Contains(caps.DocumentFormats, format) && slices.Contains(caps.DocumentFormats, "application/pdf") {
+		format = "application/pdf"
+	}
+	resolution := closestResolution(caps.Resolutions, function.getResolution())
+	return esclScanSettings{
+		ScanNamespace: "http://schemas.hp.com/imaging/escl/2011/05/03",
+		PwgNamespace:  "http://www.pwg.org/schemas/2010/12/sm",
+		Version:       "2.6",
+		ScanRegion: esclScanRegion{
+			Height:             height,
+			Width:              width,
+			XOffset:            left,
+			YOffset:            top,
+			ContentRegionUnits: "escl:ThreeHundredthsOfInches",
+		},
+		InputSource:    esclInputSource[function.source],
+		ColorMode:      esclColorMode[function.mode],
+		XResolution:    resolution,
+		YResolution:    resolution,
+		DocumentFormat: format,
+		Duplex:         function.duplex,
+		Brightness:     esclAdjustment(function.adjustments.brightness, capabilities.Brightness),
+		Contrast:       esclAdjustment(function.adjustments.contrast, capabilities.Contrast),
+	}
+}
+
+func (backend esclBackend) getScannerCapabilities() (*esclCapabilities, error) {
+	resp, err := http.Get(backend.endpoint + "/ScannerCapabilities")
+	if err != nil {
+		fmt.Println("Failed to get scanner capabilities " + err.Error())
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get scanner capabilities failed with status code: %s", resp.Status)
+	}
+	var capabilities esclCapabilities
+	if err := xml.NewDecoder(resp.Body).Decode(&capabilities); err != nil {
+		fmt.Println("Cannot unmarshal XML: " + err.Error())
+		return nil, err
+	}
+	return &capabilities, nil
+}
+
+func (capabilities esclCapabilities) inputCaps(source ScannerSource, duplex bool) *esclInputCaps {
+	if source == adf && duplex {
+		return capabilities.AdfDuplex
+	}
+	if source == adf {
+		return capabilities.AdfSimplex
+	}
+	return capabilities.Platen
+}
+
+func (backend esclBackend) scan(ctx context.Context, function ScannerFunction, progress scanProgress) (io.ReadCloser, string, error) {
+	fmt.Println("Starting scan")
+	capabilities, err := backend.getScannerCapabilities()
+	if err != nil {
+		return nil, "", err
+	}
+	caps := capabilities.inputCaps(function.source, function.duplex)
+	if caps == nil {
+		return nil, "", fmt.Errorf("scanner does not support source %s (duplex: %t)", function.source, function.duplex)
+	}
+	settings := newEsclScanSettings(function, capabilities, caps)
+	marshalled, err := xml.Marshal(settings)
+	if err != nil {
+		fmt.Println("Cannot encode XML: " + err.Error())
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, backend.endpoint+"/ScanJobs", bytes.NewReader(append([]byte(xml.Header), marshalled...)))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Println("Post failed: " + err.Error())
+		return nil, "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		fmt.Println("Post failed with status code: " + resp.Status)
+		return nil, "", fmt.Errorf("post failed with status code: %s", resp.Status)
+	}
+	jobUrl, err := backend.resolve(resp.Header.Get("Location"))
+	if err != nil {
+		return nil, "", err
+	}
+	fmt.Printf("Created scan job %s\n", jobUrl)
+	pages, err := backend.getDocuments(ctx, jobUrl, progress)
+	if ctx.Err() != nil {
+		// Stop the scanner, it would keep feeding pages otherwise
+		backend.cancelJob(jobUrl)
+		return nil, "", ctx.Err()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if len(pages) == 0 {
+		return nil, "", fmt.Errorf("scanner returned no pages")
+	}
+	fileName := fmt.Sprintf("scan_%s.pdf", time.Now().Format("2006-01-02_15-04-05"))
+	var pdf bytes.Buffer
+	if settings.DocumentFormat == "application/pdf" {
+		readers := []io.ReadSeeker{}
+		for _, page := range pages {
+			readers = append(readers, bytes.NewReader(page))
+		}
+		err = api.MergeRaw(readers, &pdf, false, model.NewDefaultConfiguration())
+	} else {
+		readers := []io.Reader{}
+		for _, page := range pages {
+			readers = append(readers, bytes.NewReader(page))
+		}
+		err = api.ImportImages(nil, &pdf, readers, nil, model.NewDefaultConfiguration())
+	}
+	if err != nil {
+		fmt.Printf("failed to create pdf: %s\n", err.Error())
+		return nil, "", err
+	}
+	return io.NopCloser(&pdf), fileName, nil
+}
+
+// Resolves the job location, which may be relative to the eSCL root.
+func (backend esclBackend) resolve(location string) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("scanner did not return a job location")
+	}
+	base, err := url.Parse(backend.endpoint + "/")
+	if err != nil {
+		return "", err
+	}
+	reference, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(reference).String(), nil
+}
+
+// Deletes a job, which aborts it if the scanner is still working on it.
+func (backend esclBackend) cancelJob(jobUrl string) {
+	fmt.Printf("Canceling scan job %s\n", jobUrl)
+	req, err := http.NewRequest(http.MethodDelete, jobUrl, nil)
+	if err != nil {
+		fmt.Println("Could not create delete request for job " + err.Error())
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Println("Cancel job failed: " + err.Error())
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println("Cancel job failed with status code: " + resp.Status)
+	}
+}
+
+// Pulls pages of a job until the scanner reports that there are no more.
+func (backend esclBackend) getDocuments(ctx context.Context, jobUrl string, progress scanProgress) ([][]byte, error) {
+	client := &http.Client{
+		Timeout: time.Minute * 20,
+	}
+	pages := [][]byte{}
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, jobUrl+"/NextDocument", nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			fmt.Println("Get next document failed: " + err.Error())
+			return nil, err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			page, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			pages = append(pages, page)
+			fmt.Printf("Received page %d\n", len(pages))
+			progress(stageScanning, len(pages))
+		case http.StatusNotFound:
+			resp.Body.Close()
+			return pages, nil
+		case http.StatusServiceUnavailable:
+			// Scanner is still busy with the page
+			resp.Body.Close()
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Second):
+			}
+		default:
+			resp.Body.Close()
+			return nil, fmt.Errorf("get next document failed with status code: %s", resp.Status)
+		}
+	}
+}
+
+func (backend esclBackend) listDevices() ([]scannerDevice, error) {
+	capabilities, err := backend.getScannerCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	return []scannerDevice{{
+		id:   backend.endpoint,
+		name: capabilities.MakeAndModel,
+	}}, nil
+}
+
+func (backend esclBackend) getCapabilities() (scannerCapabilities, error) {
+	result := scannerCapabilities{}
+	capabilities, err := backend.getScannerCapabilities()
+	if err != nil {
+		return result, err
+	}
+	for _, source := range []ScannerSource{adf, flatbed} {
+		caps := capabilities.inputCaps(source, false)
+		if caps == nil {
+			continue
+		}
+		result.sources = append(result.sources, source)
+		result.width = max(result.width, esclToMm(caps.MaxWidth))
+		result.height = max(result.height, esclToMm(caps.MaxHeight))
+		for _, mode := range []ScannerMode{color, gray, lineart} {
+			if slices.Contains(caps.ColorModes, esclColorMode[mode]) && !slices.Contains(result.modes, mode) {
+				result.modes = append(result.modes, mode)
+			}
+		}
+		for _, resolution := range caps.Resolutions {
+			if !slices.Contains(result.resolutions, resolution) {
+				result.resolutions = append(result.resolutions, resolution)
+			}
+		}
+	}
+	result.duplex = capabilities.AdfDuplex != nil
+	result.formats = []ScannerFormat{formatPdf}
+	slices.Sort(result.resolutions)
+	return result, nil
+}
+
+func (backend esclBackend) getFile(fileName string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("eSCL scanners do not store scanned files")
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+const esclTestCapabilities = `<?xml version="1.0" encoding="UTF-8"?>
+<scan:ScannerCapabilities xmlns:scan="http://schemas.hp.com/imaging/escl/2011/05/03" xmlns:pwg="http://www.pwg.org/schemas/2010/12/sm">
+  <pwg:MakeAndModel>Test MFP</pwg:MakeAndModel>
+  <scan:Platen>
+    <scan:PlatenInputCaps>
+      <scan:MaxWidth>2550</scan:MaxWidth>
+      <scan:MaxHeight>3508</scan:MaxHeight>
+      <scan:SettingProfiles><scan:SettingProfile>
+        <scan:ColorModes><scan:ColorMode>RGB24</scan:ColorMode><scan:ColorMode>Grayscale8</scan:ColorMode></scan:ColorModes>
+        <scan:DocumentFormats><pwg:DocumentFormat>image/jpeg</pwg:DocumentFormat><pwg:DocumentFormat>application/pdf</pwg:DocumentFormat></scan:DocumentFormats>
+        <scan:SupportedResolutions><scan:DiscreteResolutions>
+          <scan:DiscreteResolution><scan:XResolution>150</scan:XResolution><scan:YResolution>150</scan:YResolution></scan:DiscreteResolution>
+          <scan:DiscreteResolution><scan:XResolution>300</scan:XResolution><scan:YResolution>300</scan:YResolution></scan:DiscreteResolution>
+        </scan:DiscreteResolutions></scan:SupportedResolutions>
+      </scan:SettingProfile></scan:SettingProfiles>
+    </scan:PlatenInputCaps>
+  </scan:Platen>
+  <scan:Adf>
+    <scan:AdfSimplexInputCaps>
+      <scan:MaxWidth>2550</scan:MaxWidth>
+      <scan:MaxHeight>4200</scan:MaxHeight>
+      <scan:SettingProfiles><scan:SettingProfile>
+        <scan:ColorModes><scan:ColorMode>Grayscale8</scan:ColorMode><scan:ColorMode>BlackAndWhite1</scan:ColorMode></scan:ColorModes>
+        <scan:DocumentFormats><pwg:DocumentFormat>image/jpeg</pwg:DocumentFormat></scan:DocumentFormats>
+        <scan:SupportedResolutions><scan:DiscreteResolutions>
+          <scan:DiscreteResolution><scan:XResolution>200</scan:XResolution><scan:YResolution>200</scan:YResolution></scan:DiscreteResolution>
+        </scan:DiscreteResolutions></scan:SupportedResolutions>
+      </scan:SettingProfile></scan:SettingProfiles>
+    </scan:AdfSimplexInputCaps>
+  </scan:Adf>
+  <scan:BrightnessSupport><scan:Min>0</scan:Min><scan:Max>1000</scan:Max><scan:Normal>500</scan:Normal><scan:Step>1</scan:Step></scan:BrightnessSupport>
+</scan:ScannerCapabilities>`
+
+// Settings as posted by the backend, matched by local names.
+type esclPostedSettings struct {
+	Width          int    `xml:"ScanRegions>ScanRegion>Width"`
+	Height         int    `xml:"ScanRegions>ScanRegion>Height"`
+	InputSource    string `xml:"InputSource"`
+	ColorMode      string `xml:"ColorMode"`
+	XResolution    int    `xml:"XResolution"`
+	DocumentFormat string `xml:"DocumentFormat"`
+	Duplex         bool   `xml:"Duplex"`
+	Brightness     *int   `xml:"Brightness"`
+	Contrast       *int   `xml:"Contrast"`
+}
+
+// In-process eSCL scanner. NextDocument answers with the queued responses in order, then with 404.
+type esclTestServer struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	settings  esclPostedSettings
+	documents []int
+	pages     [][]byte
+	deleted   []string
+	// closed once NextDocument was asked for the first time
+	polled chan struct{}
+}
+
+func newEsclTestServer(t *testing.T, documents []int, pages [][]byte) *esclTestServer {
+	t.Helper()
+	server := &esclTestServer{documents: documents, pages: pages, polled: make(chan struct{})}
+	var polledOnce sync.Once
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /eSCL/ScannerCapabilities", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		io.WriteString(w, esclTestCapabilities)
+	})
+	mux.HandleFunc("POST /eSCL/ScanJobs", func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		if err := xml.NewDecoder(r.Body).Decode(&server.settings); err != nil {
+			t.Errorf("cannot decode scan settings: %s", err)
+		}
+		// Relative location, resolved against the eSCL root
+		w.Header().Set("Location", "ScanJobs/42")
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("GET /eSCL/ScanJobs/42/NextDocument", func(w http.ResponseWriter, r *http.Request) {
+		polledOnce.Do(func() { close(server.polled) })
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		if len(server.documents) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		status := server.documents[0]
+		if status != http.StatusServiceUnavailable || len(server.documents) > 1 {
+			// The last 503 repeats, the scanner stays busy
+			server.documents = server.documents[1:]
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(server.pages[0])
+		server.pages = server.pages[1:]
+	})
+	mux.HandleFunc("DELETE /eSCL/ScanJobs/42", func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		server.deleted = append(server.deleted, r.URL.Path)
+	})
+	server.Server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testJpeg(t *testing.T) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, image.NewGray(image.Rect(0, 0, 20, 30)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestEsclScan(t *testing.T) {
+	server := newEsclTestServer(t,
+		[]int{http.StatusOK, http.StatusServiceUnavailable, http.StatusOK},
+		[][]byte{testJpeg(t), testJpeg(t)},
+	)
+	backend := newEsclBackend(server.URL + "/eSCL/")
+	function := ScannerFunction{
+		mode:        gray,
+		source:      adf,
+		resolution:  300,
+		adjustments: ScannerAdjustments{brightness: 50, contrast: 20},
+	}
+	received := []int{}
+	file, fileName, err := backend.scan(context.Background(), function, func(stage ScanStage, pages int) {
+		received = append(received, pages)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if fileName == "" {
+		t.Error("empty file name")
+	}
+
+	// 50 of 100 is halfway between normal and maximum
+	brightness := 750
+	want := esclPostedSettings{
+		// Without an area the default page of 215x297 mm is scanned
+		Width:          mmToEscl(215),
+		Height:         mmToEscl(297),
+		InputSource:    "Feeder",
+		ColorMode:      "Grayscale8",
+		XResolution:    200,
+		DocumentFormat: "image/jpeg",
+		Brightness:     &brightness,
+	}
+	if !reflect.DeepEqual(server.settings, want) {
+		t.Errorf("posted settings = %+v (brightness %v), want %+v (brightness %v)", server.settings, server.settings.Brightness, want, *want.Brightness)
+	}
+
+	pdf, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := api.PageCount(bytes.NewReader(pdf), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 2 {
+		t.Errorf("page count = %d, want 2", pages)
+	}
+	if !reflect.DeepEqual(received, []int{1, 2}) {
+		t.Errorf("reported pages = %v, want [1 2]", received)
+	}
+	if len(server.deleted) != 0 {
+		t.Errorf("finished job was deleted: %v", server.deleted)
+	}
+}
+
+func TestEsclScanNoPages(t *testing.T) {
+	server := newEsclTestServer(t, nil, nil)
+	backend := newEsclBackend(server.URL + "/eSCL")
+	_, _, err := backend.scan(context.Background(), ScannerFunction{mode: color, source: flatbed}, func(ScanStage, int) {})
+	if err == nil {
+		t.Error("expected an error for a job without pages")
+	}
+}
+
+func TestEsclScanCancel(t *testing.T) {
+	// The scanner never finishes the first page
+	server := newEsclTestServer(t, []int{http.StatusServiceUnavailable}, nil)
+	backend := newEsclBackend(server.URL + "/eSCL")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-server.polled
+		cancel()
+	}()
+	done := make(chan error)
+	go func() {
+		_, _, err := backend.scan(ctx, ScannerFunction{mode: color, source: adf}, func(ScanStage, int) {})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan was not canceled")
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if !reflect.DeepEqual(server.deleted, []string{"/eSCL/ScanJobs/42"}) {
+		t.Errorf("deleted jobs = %v, want the canceled job", server.deleted)
+	}
+}
+
+func TestEsclCapabilities(t *testing.T) {
+	server := newEsclTestServer(t, nil, nil)
+	capabilities, err := newEsclBackend(server.URL + "/eSCL").getCapabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(capabilities.sources, []ScannerSource{adf, flatbed}) {
+		t.Errorf("sources = %v", capabilities.sources)
+	}
+	if !reflect.DeepEqual(capabilities.modes, []ScannerMode{gray, lineart, color}) {
+		t.Errorf("modes = %v", capabilities.modes)
+	}
+	if !reflect.DeepEqual(capabilities.resolutions, []int{150, 200, 300}) {
+		t.Errorf("resolutions = %v", capabilities.resolutions)
+	}
+	if capabilities.width != esclToMm(2550) || capabilities.height != esclToMm(4200) || capabilities.duplex {
+		t.Errorf("area %dx%d, duplex %t", capabilities.width, capabilities.height, capabilities.duplex)
+	}
+}
@@ -3,16 +3,19 @@ module mariusrogg/telegram-printer-scanner
 go 1.24
 
 require (
-	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1 // indirect
+	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+	github.com/pdfcpu/pdfcpu v0.11.0
+	golang.org/x/image v0.28.0
+	golang.org/x/text v0.26.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
 	github.com/hhrutter/lzw v1.0.0 // indirect
 	github.com/hhrutter/pkcs7 v0.2.0 // indirect
 	github.com/hhrutter/tiff v1.0.2 // indirect
 	github.com/mattn/go-runewidth v0.0.16 // indirect
-	github.com/pdfcpu/pdfcpu v0.11.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
 	golang.org/x/crypto v0.39.0 // indirect
-	golang.org/x/image v0.28.0 // indirect
-	golang.org/x/text v0.26.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
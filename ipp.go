@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Delimiter tags separating the attribute groups of an IPP message (RFC 8010).
+const (
+	ippTagOperation   byte = 0x01
+	ippTagJob         byte = 0x02
+	ippTagEnd         byte = 0x03
+	ippTagPrinter     byte = 0x04
+	ippTagUnsupported byte = 0x05
+)
+
+// Value tags of the attributes used by the bot.
+const (
+	ippTagInteger         byte = 0x21
+	ippTagBoolean         byte = 0x22
+	ippTagEnum            byte = 0x23
+	ippTagRange           byte = 0x33
+	ippTagText            byte = 0x41
+	ippTagName            byte = 0x42
+	ippTagKeyword         byte = 0x44
+	ippTagUri             byte = 0x45
+	ippTagCharset         byte = 0x47
+	ippTagNaturalLanguage byte = 0x48
+	ippTagMimeMediaType   byte = 0x49
+)
+
+type ippOperation uint16
+
+const (
+	ippPrintJob             ippOperation = 0x0002
+	ippCancelJob            ippOperation = 0x0008
+	ippGetJobs              ippOperation = 0x000A
+	ippGetPrinterAttributes ippOperation = 0x000B
+)
+
+// Status codes up to 0x00FF are successful (RFC 8011, section 4.1.6).
+const ippStatusMaxSuccess uint16 = 0x00FF
+
+type ippRange struct {
+	lower int
+	upper int
+}
+
+type ippAttribute struct {
+	tag    byte
+	name   string
+	values []any
+}
+
+type ippGroup struct {
+	tag        byte
+	attributes []ippAttribute
+}
+
+type ippMessage struct {
+	// operation for requests, status code for responses
+	code      uint16
+	requestId uint32
+	groups    []ippGroup
+}
+
+func newIppAttribute(tag byte, name string, values ...any) ippAttribute {
+	return ippAttribute{
+		tag:    tag,
+		name:   name,
+		values: values,
+	}
+}
+
+func (message ippMessage) encode() ([]byte, error) {
+	var buffer bytes.Buffer
+	// IPP version 1.1
+	buffer.Write([]byte{1, 1})
+	binary.Write(&buffer, binary.BigEndian, message.code)
+	binary.Write(&buffer, binary.BigEndian, message.requestId)
+	for _, group := range message.groups {
+		buffer.WriteByte(group.tag)
+		for _, attribute := range group.attributes {
+			for i, value := range attribute.values {
+				name := attribute.name
+				if i > 0 {
+					// Additional values are encoded without a name
+					name = ""
+				}
+				encoded, err := encodeIppValue(attribute.tag, value)
+				if err != nil {
+					return nil, fmt.Errorf("cannot encode attribute %s: %w", attribute.name, err)
+				}
+				buffer.WriteByte(attribute.tag)
+				binary.Write(&buffer, binary.BigEndian, uint16(len(name)))
+				buffer.WriteString(name)
+				binary.Write(&buffer, binary.BigEndian, uint16(len(encoded)))
+				buffer.Write(encoded)
+			}
+		}
+	}
+	buffer.WriteByte(ippTagEnd)
+	return buffer.Bytes(), nil
+}
+
+func encodeIppValue(tag byte, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case int:
+		if tag != ippTagInteger && tag != ippTagEnum {
+			return nil, fmt.Errorf("integer value for tag 0x%02x", tag)
+		}
+		return binary.BigEndian.AppendUint32(nil, uint32(int32(v))), nil
+	case bool:
+		if v {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case ippRange:
+		encoded := binary.BigEndian.AppendUint32(nil, uint32(int32(v.lower)))
+		return binary.BigEndian.AppendUint32(encoded, uint32(int32(v.upper))), nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T", value)
+}
+
+func decodeIppMessage(reader io.Reader) (*ippMessage, error) {
+	var header struct {
+		Version   [2]byte
+		Code      uint16
+		RequestId uint32
+	}
+	if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("cannot read ipp header: %w", err)
+	}
+	message := ippMessage{
+		code:      header.Code,
+		requestId: header.RequestId,
+	}
+	var group *ippGroup
+	for {
+		var tag [1]byte
+		if _, err := io.ReadFull(reader, tag[:]); err != nil {
+			return nil, fmt.Errorf("cannot read ipp tag: %w", err)
+		}
+		if tag[0] == ippTagEnd {
+			return &message, nil
+		}
+		if tag[0] < 0x10 {
+			message.groups = append(message.groups, ippGroup{tag: tag[0]})
+			group = &message.groups[len(message.groups)-1]
+			continue
+		}
+		if group == nil {
+			return nil, fmt.Errorf("ipp attribute outside of a group")
+		}
+		name, err := readIppString(reader)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := readIppString(reader)
+		if err != nil {
+			return nil, err
+		}
+		value := decodeIppValue(tag[0], []byte(raw))
+		if name == "" && len(group.attributes) > 0 {
+			last := &group.attributes[len(group.attributes)-1]
+			last.values = append(last.values, value)
+		} else {
+			group.attributes = append(group.attributes, newIppAttribute(tag[0], name, value))
+		}
+	}
+}
+
+func readIppString(reader io.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return "", fmt.Errorf("cannot read ipp length: %w", err)
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(reader, value); err != nil {
+		return "", fmt.Errorf("cannot read ipp value: %w", err)
+	}
+	return string(value), nil
+}
+
+func decodeIppValue(tag byte, raw []byte) any {
+	switch {
+	case (tag == ippTagInteger || tag == ippTagEnum) && len(raw) == 4:
+		return int(int32(binary.BigEndian.Uint32(raw)))
+	case tag == ippTagBoolean && len(raw) == 1:
+		return raw[0] != 0
+	case tag == ippTagRange && len(raw) == 8:
+		return ippRange{
+			lower: int(int32(binary.BigEndian.Uint32(raw[:4]))),
+			upper: int(int32(binary.BigEndian.Uint32(raw[4:]))),
+		}
+	}
+	return string(raw)
+}
+
+// Returns all groups of the message with the given delimiter tag.
+func (message ippMessage) getGroups(tag byte) []ippGroup {
+	groups := []ippGroup{}
+	for _, group := range message.groups {
+		if group.tag == tag {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+func (group ippGroup) getAttribute(name string) *ippAttribute {
+	for _, attribute := range group.attributes {
+		if attribute.name == name {
+			return &attribute
+		}
+	}
+	return nil
+}
+
+func (group ippGroup) getInt(name string) int {
+	attribute := group.getAttribute(name)
+	if attribute == nil || len(attribute.values) == 0 {
+		return 0
+	}
+	value, _ := attribute.values[0].(int)
+	return value
+}
+
+func (group ippGroup) getString(name string) string {
+	attribute := group.getAttribute(name)
+	if attribute == nil || len(attribute.values) == 0 {
+		return ""
+	}
+	value, _ := attribute.values[0].(string)
+	return value
+}
+
+func (group ippGroup) getStrings(name string) []string {
+	values := []string{}
+	attribute := group.getAttribute(name)
+	if attribute == nil {
+		return values
+	}
+	for _, value := range attribute.values {
+		if s, ok := value.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIppEncodeGolden(t *testing.T) {
+	message := ippMessage{
+		code:      uint16(ippPrintJob),
+		requestId: 7,
+		groups: []ippGroup{
+			{tag: ippTagOperation, attributes: []ippAttribute{
+				newIppAttribute(ippTagCharset, "attributes-charset", "utf-8"),
+			}},
+			{tag: ippTagJob, attributes: []ippAttribute{
+				newIppAttribute(ippTagInteger, "copies", 2),
+				newIppAttribute(ippTagRange, "page-ranges", ippRange{lower: 1, upper: 3}, ippRange{lower: 5, upper: 5}),
+			}},
+		},
+	}
+	want := []byte{
+		0x01, 0x01, // version 1.1
+		0x00, 0x02, // Print-Job
+		0x00, 0x00, 0x00, 0x07, // request id
+		0x01, // operation group
+		0x47, 0x00, 0x12, 'a', 't', 't', 'r', 'i', 'b', 'u', 't', 'e', 's', '-', 'c', 'h', 'a', 'r', 's', 'e', 't',
+		0x00, 0x05, 'u', 't', 'f', '-', '8',
+		0x02, // job group
+		0x21, 0x00, 0x06, 'c', 'o', 'p', 'i', 'e', 's',
+		0x00, 0x04, 0x00, 0x00, 0x00, 0x02,
+		0x33, 0x00, 0x0b, 'p', 'a', 'g', 'e', '-', 'r', 'a', 'n', 'g', 'e', 's',
+		0x00, 0x08, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x03,
+		// additional value without a name
+		0x33, 0x00, 0x00,
+		0x00, 0x08, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x05,
+		0x03, // end
+	}
+	encoded, err := message.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, want) {
+		t.Errorf("encoded\n% x\nwant\n% x", encoded, want)
+	}
+}
+
+func TestIppRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups []ippGroup
+	}{
+		{
+			name: "single values",
+			groups: []ippGroup{{tag: ippTagOperation, attributes: []ippAttribute{
+				newIppAttribute(ippTagCharset, "attributes-charset", "utf-8"),
+				newIppAttribute(ippTagUri, "printer-uri", "ipp://printer:631/ipp/print"),
+				newIppAttribute(ippTagInteger, "job-id", 42),
+				newIppAttribute(ippTagEnum, "printer-state", 3),
+				newIppAttribute(ippTagBoolean, "printer-is-accepting-jobs", true),
+			}}},
+		},
+		{
+			name: "several values per attribute",
+			groups: []ippGroup{{tag: ippTagPrinter, attributes: []ippAttribute{
+				newIppAttribute(ippTagKeyword, "printer-state-reasons", "media-empty-error", "toner-low-warning"),
+				newIppAttribute(ippTagKeyword, "sides-supported", "one-sided", "two-sided-long-edge", "two-sided-short-edge"),
+			}}},
+		},
+		{
+			name: "rangeOfInteger",
+			groups: []ippGroup{{tag: ippTagJob, attributes: []ippAttribute{
+				newIppAttribute(ippTagRange, "page-ranges", ippRange{lower: 1, upper: 3}, ippRange{lower: 7, upper: 12}),
+				newIppAttribute(ippTagRange, "copies-supported", ippRange{lower: 1, upper: 999}),
+			}}},
+		},
+		{
+			name: "negative integer",
+			groups: []ippGroup{{tag: ippTagJob, attributes: []ippAttribute{
+				newIppAttribute(ippTagInteger, "offset", -5),
+			}}},
+		},
+		{
+			name: "group without attributes",
+			groups: []ippGroup{
+				{tag: ippTagOperation, attributes: []ippAttribute{
+					newIppAttribute(ippTagCharset, "attributes-charset", "utf-8"),
+				}},
+				{tag: ippTagUnsupported},
+				{tag: ippTagJob, attributes: []ippAttribute{
+					newIppAttribute(ippTagInteger, "job-id", 1),
+				}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message := ippMessage{code: 0x0001, requestId: 3, groups: test.groups}
+			encoded, err := message.encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := decodeIppMessage(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.code != message.code || decoded.requestId != message.requestId {
+				t.Errorf("header = %x/%d, want %x/%d", decoded.code, decoded.requestId, message.code, message.requestId)
+			}
+			if len(decoded.groups) != len(test.groups) {
+				t.Fatalf("decoded %d groups, want %d", len(decoded.groups), len(test.groups))
+			}
+			for i, group := range test.groups {
+				if decoded.groups[i].tag != group.tag {
+					t.Errorf("group %d tag = %x, want %x", i, decoded.groups[i].tag, group.tag)
+				}
+				if len(group.attributes) == 0 {
+					if len(decoded.groups[i].attributes) != 0 {
+						t.Errorf("group %d has attributes %v, want none", i, decoded.groups[i].attributes)
+					}
+					continue
+				}
+				if !reflect.DeepEqual(decoded.groups[i].attributes, group.attributes) {
+					t.Errorf("group %d attributes = %v, want %v", i, decoded.groups[i].attributes, group.attributes)
+				}
+			}
+		})
+	}
+}
+
+func TestIppGroupGetters(t *testing.T) {
+	group := ippGroup{tag: ippTagPrinter, attributes: []ippAttribute{
+		newIppAttribute(ippTagName, "printer-name", "office"),
+		newIppAttribute(ippTagEnum, "printer-state", 4),
+		newIppAttribute(ippTagKeyword, "printer-state-reasons", "none", "paused"),
+	}}
+	if name := group.getString("printer-name"); name != "office" {
+		t.Errorf("getString = %q", name)
+	}
+	if state := group.getInt("printer-state"); state != 4 {
+		t.Errorf("getInt = %d", state)
+	}
+	if reasons := group.getStrings("printer-state-reasons"); !reflect.DeepEqual(reasons, []string{"none", "paused"}) {
+		t.Errorf("getStrings = %v", reasons)
+	}
+	if missing := group.getInt("job-id"); missing != 0 {
+		t.Errorf("getInt of missing attribute = %d", missing)
+	}
+}
+
+func TestIppEncodeErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		attribute ippAttribute
+	}{
+		{"integer for keyword", newIppAttribute(ippTagKeyword, "sides", 1)},
+		{"unsupported type", newIppAttribute(ippTagText, "job-name", 1.5)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message := ippMessage{groups: []ippGroup{{tag: ippTagJob, attributes: []ippAttribute{test.attribute}}}}
+			if _, err := message.encode(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestIppDecodeErrors(t *testing.T) {
+	header := []byte{0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{"short header", []byte{0x01, 0x01, 0x00}, "header"},
+		{"missing end tag", header, "tag"},
+		{"attribute outside of a group", append(append([]byte{}, header...), 0x21, 0x00, 0x00, 0x00, 0x00, 0x03), "outside"},
+		{"truncated value", append(append([]byte{}, header...), 0x01, 0x21, 0x00, 0x01, 'a', 0x00, 0x04, 0x00), "value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeIppMessage(bytes.NewReader(test.input))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("err = %v, want it to mention %q", err, test.want)
+			}
+		})
+	}
+}
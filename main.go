@@ -4,80 +4,62 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"strconv"
 	"strings"
 
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 )
 
 func main() {
-	telegramBotToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-	allowedUserIdsString := strings.Split(os.Getenv("ALLOWED_TELEGRAM_USERS"), ";")
-	scannerEndpoint := os.Getenv("SCANNER_ENDPOINT")
-	scannerDeviceId := os.Getenv("SCANNER_DEVICE_ID")
-	paperlessEndpoint := os.Getenv("PAPERLESS_ENDPOINT")
-	paperlessToken := os.Getenv("PAPERLESS_TOKEN")
+	configFile := os.Getenv("CONFIG_FILE")
+	fmt.Printf("CONFIG_FILE: %s\n", configFile)
 
-	fmt.Printf("TELEGRAM_BOT_TOKEN: %s\n", telegramBotToken)
-	fmt.Printf("ALLOWED_TELEGRAM_USERS: %s\n", allowedUserIdsString)
-	fmt.Printf("SCANNER_ENDPOINT: %s\n", scannerEndpoint)
-	fmt.Printf("SCANNER_DEVICE_ID: %s\n", scannerDeviceId)
-	fmt.Printf("PAPERLESS_ENDPOINT: %s\n", paperlessEndpoint)
-	fmt.Printf("PAPERLESS_TOKEN: %s\n", paperlessToken)
+	config, err := loadConfig(configFile)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	// Disable config dir for pdfcpu
 	api.DisableConfigDir()
 
-	var allowedUserIds []int64
+	fmt.Println(config.Telegram.AllowedUsers)
+	config.Targets.applyLabels()
 
-	for _, id := range allowedUserIdsString {
-		n, err := strconv.ParseInt(id, 10, 64)
-		if err == nil {
-			allowedUserIds = append(allowedUserIds, n)
-		} else {
-			fmt.Printf("Failed to parse user %s (error: %s)", id, err)
+	var printer *printer
+	if config.Targets.Printer.Endpoint != "" {
+		printer, err = newPrinter(config.Targets.Printer.Endpoint)
+		if err != nil {
+			log.Panic(err)
 		}
 	}
 
-	scannerFunctions := []ScannerFunction{{
-		source: adf,
-		mode:   color,
-		target: telegram,
-	}, {
-		source: flatbed,
-		mode:   color,
-		target: telegram,
-	}, {
-		source: adf,
-		mode:   gray,
-		target: telegram,
-	}, {
-		source: flatbed,
-		mode:   gray,
-		target: telegram,
-	}, {
-		source: adf,
-		mode:   gray,
-		target: paperless,
-	}, {
-		source: flatbed,
-		mode:   gray,
-		target: paperless,
-	}, {
-		source: adf,
-		mode:   color,
-		target: paperless,
-	}, {
-		source: flatbed,
-		mode:   color,
-		target: paperless,
-	}}
-
-	fmt.Println(allowedUserIds)
+	scanners := []*scanner{}
+	for _, scannerConfig := range config.Scanners {
+		backend, err := newScannerBackend(scannerConfig.Backend, scannerConfig.Endpoint, scannerConfig.Device)
+		if err != nil {
+			log.Panic(err)
+		}
+		if err := checkDevice(backend, scannerConfig.Device); err != nil {
+			log.Panic(fmt.Errorf("scanner %s: %w", scannerConfig.displayName(), err))
+		}
+		customAreas, err := parseScannerAreas(strings.Join(scannerConfig.Areas, ";"))
+		if err != nil {
+			log.Panic(err)
+		}
+		scanners = append(scanners, newScanner(scannerConfig.displayName(), backend, config.scannerFunctions(scannerConfig), customAreas, scannerConfig.blankPageThreshold()))
+	}
 
-	scanner := newScanner(scannerEndpoint, scannerFunctions, scannerDeviceId)
+	libreOfficeBinary := config.LibreOffice
+	if libreOfficeBinary == "" {
+		libreOfficeBinary = "soffice"
+	}
+	converter := newConverter(libreOfficeBinary)
+	ocrBinary := config.Ocr.Binary
+	if ocrBinary == "" {
+		ocrBinary = "ocrmypdf"
+	}
+	ocr := newOcrEngine(ocrBinary, config.Ocr.Language)
 
-	_, err := newTelegramBot(allowedUserIds, telegramBotToken, scanner, paperlessEndpoint, paperlessToken)
+	_, err = newTelegramBot(config.Telegram.AllowedUsers, config.Telegram.Token, scanners, printer, converter, ocr, config.Targets.Paperless.Endpoint, config.Targets.Paperless.Token)
 
 	if err != nil {
 		log.Panic(err)
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Longest time ocrmypdf may take for a scan before it is stopped
+const ocrTimeout = time.Minute * 10
+
+// Adds a text layer to scanned pdfs with ocrmypdf, which runs tesseract on every page.
+type ocrEngine struct {
+	// ocrmypdf binary, empty if it is not installed
+	path string
+	// tesseract languages, e.g. eng+deu
+	language string
+}
+
+// Creates an ocr engine. binary is looked up in PATH, a missing binary disables ocr.
+func newOcrEngine(binary string, language string) *ocrEngine {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		fmt.Printf("ocrmypdf not found (%s), scans are sent without text layer\n", binary)
+		path = ""
+	}
+	return &ocrEngine{
+		path:     path,
+		language: language,
+	}
+}
+
+func (engine ocrEngine) available() bool {
+	return engine.path != ""
+}
+
+// Returns a searchable copy of the pdf. Pages that already contain text are left as they are.
+// ocrmypdf is stopped when the context is canceled or after ocrTimeout.
+func (engine ocrEngine) recognize(ctx context.Context, pdf io.Reader) (io.ReadSeeker, error) {
+	if !engine.available() {
+		return nil, fmt.Errorf("ocrmypdf is not installed")
+	}
+	dir, err := os.MkdirTemp("", "telegram-printer-scanner")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input.pdf")
+	output := filepath.Join(dir, "output.pdf")
+	inputFile, err := os.Create(input)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(inputFile, pdf)
+	inputFile.Close()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--skip-text", "--quiet"}
+	if engine.language != "" {
+		args = append(args, "--language", engine.language)
+	}
+	ctx, cancel := context.WithTimeout(ctx, ocrTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, engine.path, append(args, input, output)...)
+	// tesseract workers may keep the output open after ocrmypdf was killed
+	cmd.WaitDelay = time.Second * 10
+	combined, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("ocrmypdf was stopped: %w", ctx.Err())
+	}
+	if err != nil {
+		fmt.Printf("ocrmypdf failed: %s\n", string(combined))
+		return nil, fmt.Errorf("ocrmypdf failed: %w", err)
+	}
+	recognized, err := os.ReadFile(output)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(recognized), nil
+}
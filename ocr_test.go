@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes a fake ocrmypdf that logs its arguments and runs the given shell commands.
+func fakeOcrmypdf(t *testing.T, commands string) (*ocrEngine, string) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("needs a shell")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "args.log")
+	script := filepath.Join(dir, "ocrmypdf")
+	content := "#!/bin/sh\necho \"$*\" > " + log + "\n" + commands + "\n"
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return newOcrEngine(script, "eng+deu"), log
+}
+
+func TestOcrRecognize(t *testing.T) {
+	// Copies the input and appends a marker for the text layer
+	engine, log := fakeOcrmypdf(t, `for last; do :; done
+for arg; do [ "$arg" = "$last" ] && break; input="$arg"; done
+cat "$input" > "$last"
+printf ' text' >> "$last"`)
+	recognized, err := engine.recognize(context.Background(), strings.NewReader("%PDF-1.4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(recognized)
+	if string(content) != "%PDF-1.4 text" {
+		t.Errorf("recognized = %q", content)
+	}
+	args, _ := os.ReadFile(log)
+	if !strings.HasPrefix(string(args), "--skip-text --quiet --language eng+deu ") {
+		t.Errorf("arguments = %q", args)
+	}
+}
+
+func TestOcrRecognizeFails(t *testing.T) {
+	engine, _ := fakeOcrmypdf(t, "echo 'no tesseract' >&2; exit 3")
+	if _, err := engine.recognize(context.Background(), strings.NewReader("%PDF-1.4")); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestOcrRecognizeCanceled(t *testing.T) {
+	engine, _ := fakeOcrmypdf(t, "exec sleep 30")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	started := time.Now()
+	_, err := engine.recognize(ctx, strings.NewReader("%PDF-1.4"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context error", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second*5 {
+		t.Errorf("ocrmypdf was stopped after %s", elapsed)
+	}
+}
+
+func TestOcrNotInstalled(t *testing.T) {
+	engine := newOcrEngine(filepath.Join(t.TempDir(), "ocrmypdf"), "")
+	if engine.available() {
+		t.Error("missing binary is available")
+	}
+	if _, err := engine.recognize(context.Background(), strings.NewReader("")); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type PrintCopies int
+
+// Upper limit for typed copy counts, so a typo does not empty the paper tray
+const maxPrintCopies = 50
+
+func (pc PrintCopies) String() string {
+	return strconv.Itoa(int(pc))
+}
+
+// Parses a copy count sent as text.
+func parsePrintCopies(text string) (PrintCopies, error) {
+	copies, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || copies < 1 || copies > maxPrintCopies {
+		return 0, fmt.Errorf("the number of copies must be between 1 and %d", maxPrintCopies)
+	}
+	return PrintCopies(copies), nil
+}
+
+type PrintPageRanges string
+
+const (
+	allPages PrintPageRanges = "All pages"
+)
+
+func (pr PrintPageRanges) String() string {
+	return string(pr)
+}
+
+// Parses page ranges like "1-3,5" into ipp ranges. All pages are returned as nil.
+func (pr PrintPageRanges) ranges() ([]ippRange, error) {
+	if pr == allPages || pr == "" {
+		return nil, nil
+	}
+	ranges := []ippRange{}
+	for _, part := range strings.Split(string(pr), ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		lower, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid page %q", bounds[0])
+		}
+		upper := lower
+		if len(bounds) == 2 {
+			upper, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid page %q", bounds[1])
+			}
+		}
+		if lower < 1 || upper < lower {
+			return nil, fmt.Errorf("invalid page range %q", part)
+		}
+		if len(ranges) > 0 && ranges[len(ranges)-1].upper >= lower {
+			return nil, fmt.Errorf("page ranges must be ascending and must not overlap")
+		}
+		ranges = append(ranges, ippRange{lower: lower, upper: upper})
+	}
+	return ranges, nil
+}
+
+type PrintSides string
+
+const (
+	oneSided          PrintSides = "One-sided"
+	twoSidedLongEdge  PrintSides = "Two-sided (long edge)"
+	twoSidedShortEdge PrintSides = "Two-sided (short edge)"
+)
+
+var printSides = map[PrintSides]string{
+	oneSided:          string(oneSided),
+	twoSidedLongEdge:  string(twoSidedLongEdge),
+	twoSidedShortEdge: string(twoSidedShortEdge),
+}
+
+var printSidesKeyword = map[PrintSides]string{
+	oneSided:          "one-sided",
+	twoSidedLongEdge:  "two-sided-long-edge",
+	twoSidedShortEdge: "two-sided-short-edge",
+}
+
+func (ps PrintSides) String() string {
+	return printSides[ps]
+}
+
+type PrintColorMode string
+
+const (
+	printColor      PrintColorMode = "Color"
+	printMonochrome PrintColorMode = "Monochrome"
+)
+
+var printColorMode = map[PrintColorMode]string{
+	printColor:      string(printColor),
+	printMonochrome: string(printMonochrome),
+}
+
+var printColorModeKeyword = map[PrintColorMode]string{
+	printColor:      "color",
+	printMonochrome: "monochrome",
+}
+
+func (pcm PrintColorMode) String() string {
+	return printColorMode[pcm]
+}
+
+type PrintMedia string
+
+const (
+	mediaA4     PrintMedia = "A4"
+	mediaA5     PrintMedia = "A5"
+	mediaLetter PrintMedia = "Letter"
+	mediaLegal  PrintMedia = "Legal"
+)
+
+var printMedia = map[PrintMedia]string{
+	mediaA4:     string(mediaA4),
+	mediaA5:     string(mediaA5),
+	mediaLetter: string(mediaLetter),
+	mediaLegal:  string(mediaLegal),
+}
+
+var printMediaKeyword = map[PrintMedia]string{
+	mediaA4:     "iso_a4_210x297mm",
+	mediaA5:     "iso_a5_148x210mm",
+	mediaLetter: "na_letter_8.5x11in",
+	mediaLegal:  "na_legal_8.5x14in",
+}
+
+func (pm PrintMedia) String() string {
+	return printMedia[pm]
+}
+
+type PrintOptions struct {
+	copies     PrintCopies
+	pageRanges PrintPageRanges
+	sides      PrintSides
+	colorMode  PrintColorMode
+	media      PrintMedia
+}
+
+// Options for printing a scanned copy: one copy of all pages, the rest is left to the printer defaults.
+func newCopyPrintOptions(duplex bool) PrintOptions {
+	options := PrintOptions{copies: 1, pageRanges: allPages}
+	if duplex {
+		options.sides = twoSidedLongEdge
+	}
+	return options
+}
+
+func (options PrintOptions) isSet() bool {
+	return options.copies != 0
+}
+
+// Converts the options to ipp job template attributes. Unset options are left to the printer defaults.
+func (options PrintOptions) jobAttributes() ([]ippAttribute, error) {
+	attributes := []ippAttribute{}
+	if options.copies > 0 {
+		attributes = append(attributes, newIppAttribute(ippTagInteger, "copies", int(options.copies)))
+	}
+	ranges, err := options.pageRanges.ranges()
+	if err != nil {
+		return nil, err
+	}
+	if len(ranges) > 0 {
+		values := []any{}
+		for _, r := range ranges {
+			values = append(values, r)
+		}
+		attributes = append(attributes, newIppAttribute(ippTagRange, "page-ranges", values...))
+	}
+	if keyword, ok := printSidesKeyword[options.sides]; ok {
+		attributes = append(attributes, newIppAttribute(ippTagKeyword, "sides", keyword))
+	}
+	if keyword, ok := printColorModeKeyword[options.colorMode]; ok {
+		attributes = append(attributes, newIppAttribute(ippTagKeyword, "print-color-mode", keyword))
+	}
+	if keyword, ok := printMediaKeyword[options.media]; ok {
+		attributes = append(attributes, newIppAttribute(ippTagKeyword, "media", keyword))
+	}
+	return attributes, nil
+}
+
+func (options PrintOptions) summary() string {
+	return fmt.Sprintf("Copies: %s\nPages: %s\nSides: %s\nColor: %s\nPaper: %s", options.copies, options.pageRanges, options.sides, options.colorMode, options.media)
+}
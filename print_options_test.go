@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrintPageRanges(t *testing.T) {
+	tests := []struct {
+		input   PrintPageRanges
+		want    []ippRange
+		wantErr bool
+	}{
+		{input: allPages, want: nil},
+		{input: "", want: nil},
+		{input: "3", want: []ippRange{{lower: 3, upper: 3}}},
+		{input: "1-3,5", want: []ippRange{{lower: 1, upper: 3}, {lower: 5, upper: 5}}},
+		{input: " 2 - 4 , 7-9 ", want: []ippRange{{lower: 2, upper: 4}, {lower: 7, upper: 9}}},
+		{input: "0", wantErr: true},
+		{input: "4-2", wantErr: true},
+		{input: "1-3,2", wantErr: true},
+		{input: "5,1", wantErr: true},
+		{input: "a-3", wantErr: true},
+		{input: "1-b", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(string(test.input), func(t *testing.T) {
+			ranges, err := test.input.ranges()
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", ranges)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ranges, test.want) {
+				t.Errorf("ranges = %v, want %v", ranges, test.want)
+			}
+		})
+	}
+}
+
+func TestPrintOptionsJobAttributes(t *testing.T) {
+	options := PrintOptions{
+		copies:     2,
+		pageRanges: "1-2",
+		sides:      twoSidedLongEdge,
+		colorMode:  printMonochrome,
+		media:      mediaA4,
+	}
+	attributes, err := options.jobAttributes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := ippGroup{tag: ippTagJob, attributes: attributes}
+	if copies := group.getInt("copies"); copies != 2 {
+		t.Errorf("copies = %d", copies)
+	}
+	if sides := group.getString("sides"); sides != "two-sided-long-edge" {
+		t.Errorf("sides = %q", sides)
+	}
+	if color := group.getString("print-color-mode"); color != "monochrome" {
+		t.Errorf("print-color-mode = %q", color)
+	}
+	if ranges := group.getAttribute("page-ranges"); ranges == nil || !reflect.DeepEqual(ranges.values, []any{ippRange{lower: 1, upper: 2}}) {
+		t.Errorf("page-ranges = %v", ranges)
+	}
+	attributes, err = PrintOptions{}.jobAttributes()
+	if err != nil || len(attributes) != 0 {
+		t.Errorf("unset options = %v, %v, want no attributes", attributes, err)
+	}
+}
+
+func TestParsePrintCopies(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    PrintCopies
+		wantErr bool
+	}{
+		{input: "1", want: 1},
+		{input: " 12 ", want: 12},
+		{input: "50", want: maxPrintCopies},
+		{input: "0", wantErr: true},
+		{input: "-3", wantErr: true},
+		{input: "1000", wantErr: true},
+		{input: "two", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			copies, err := parsePrintCopies(test.input)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %d", copies)
+				}
+				return
+			}
+			if err != nil || copies != test.want {
+				t.Errorf("copies = %d, %v, want %d", copies, err, test.want)
+			}
+		})
+	}
+}
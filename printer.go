@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type printer struct {
+	// http(s) url the ipp requests are posted to
+	endpoint string
+	// ipp(s) uri sent as printer-uri attribute
+	uri string
+}
+
+var ippRequestId atomic.Uint32
+
+// Creates a printer for an ipp://, ipps://, http:// or https:// printer url,
+// e.g. ipp://cups:631/printers/office or ipp://printer.local/ipp/print.
+func newPrinter(endpoint string) (*printer, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid printer endpoint %s: %w", endpoint, err)
+	}
+	if (parsed.Scheme == "ipp" || parsed.Scheme == "ipps") && parsed.Port() == "" {
+		// IPP uses port 631 for both ipp and ipps, http(s) urls keep the default port of their scheme
+		parsed.Host = parsed.Host + ":631"
+	}
+	uri := *parsed
+	switch parsed.Scheme {
+	case "ipp":
+		parsed.Scheme = "http"
+	case "ipps":
+		parsed.Scheme = "https"
+	case "http":
+		uri.Scheme = "ipp"
+		if parsed.Port() == "" {
+			// Without a port the ipp uri would point to 631
+			uri.Host = uri.Host + ":80"
+		}
+	case "https":
+		uri.Scheme = "ipps"
+		if parsed.Port() == "" {
+			uri.Host = uri.Host + ":443"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported printer scheme %s", parsed.Scheme)
+	}
+	return &printer{
+		endpoint: parsed.String(),
+		uri:      uri.String(),
+	}, nil
+}
+
+func (printer printer) operationAttributes() []ippAttribute {
+	return []ippAttribute{
+		newIppAttribute(ippTagCharset, "attributes-charset", "utf-8"),
+		newIppAttribute(ippTagNaturalLanguage, "attributes-natural-language", "en"),
+		newIppAttribute(ippTagUri, "printer-uri", printer.uri),
+		newIppAttribute(ippTagName, "requesting-user-name", "telegram-printer-scanner"),
+	}
+}
+
+// Sends an ipp request with an optional document and returns the decoded response.
+func (printer printer) do(operation ippOperation, groups []ippGroup, document io.Reader) (*ippMessage, error) {
+	request := ippMessage{
+		code:      uint16(operation),
+		requestId: ippRequestId.Add(1),
+		groups:    groups,
+	}
+	encoded, err := request.encode()
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader = bytes.NewReader(encoded)
+	if document != nil {
+		body = io.MultiReader(body, document)
+	}
+	client := &http.Client{
+		Timeout: time.Minute * 5,
+	}
+	resp, err := client.Post(printer.endpoint, "application/ipp", body)
+	if err != nil {
+		fmt.Println("IPP request failed: " + err.Error())
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println("IPP request failed with status code: " + resp.Status)
+		return nil, fmt.Errorf("ipp request failed with status code: %s", resp.Status)
+	}
+	response, err := decodeIppMessage(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.code > ippStatusMaxSuccess {
+		message := ""
+		for _, group := range response.getGroups(ippTagOperation) {
+			message = group.getString("status-message")
+		}
+		return nil, fmt.Errorf("ipp operation failed with status 0x%04x %s", response.code, message)
+	}
+	return response, nil
+}
+
+// Submits a pdf document as print job and returns the job id.
+func (printer printer) print(file io.Reader, fileName string, options PrintOptions) (int, error) {
+	fmt.Printf("Printing %s on %s\n", fileName, printer.uri)
+	operation := append(printer.operationAttributes(),
+		newIppAttribute(ippTagName, "job-name", fileName),
+		newIppAttribute(ippTagMimeMediaType, "document-format", "application/pdf"),
+	)
+	job, err := options.jobAttributes()
+	if err != nil {
+		return 0, err
+	}
+	groups := []ippGroup{{tag: ippTagOperation, attributes: operation}}
+	if len(job) > 0 {
+		groups = append(groups, ippGroup{tag: ippTagJob, attributes: job})
+	}
+	response, err := printer.do(ippPrintJob, groups, file)
+	if err != nil {
+		return 0, err
+	}
+	for _, group := range response.getGroups(ippTagJob) {
+		if id := group.getInt("job-id"); id != 0 {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("printer did not return a job id")
+}
+
+type PrinterState int
+
+const (
+	printerIdle       PrinterState = 3
+	printerProcessing PrinterState = 4
+	printerStopped    PrinterState = 5
+)
+
+var printerState = map[PrinterState]string{
+	printerIdle:       "idle",
+	printerProcessing: "processing",
+	printerStopped:    "stopped",
+}
+
+func (ps PrinterState) String() string {
+	if state, ok := printerState[ps]; ok {
+		return state
+	}
+	return fmt.Sprintf("unknown (%d)", int(ps))
+}
+
+type JobState int
+
+const (
+	jobPending           JobState = 3
+	jobPendingHeld       JobState = 4
+	jobProcessing        JobState = 5
+	jobProcessingStopped JobState = 6
+	jobCanceled          JobState = 7
+	jobAborted           JobState = 8
+	jobCompleted         JobState = 9
+)
+
+var jobState = map[JobState]string{
+	jobPending:           "pending",
+	jobPendingHeld:       "held",
+	jobProcessing:        "processing",
+	jobProcessingStopped: "stopped",
+	jobCanceled:          "canceled",
+	jobAborted:           "aborted",
+	jobCompleted:         "completed",
+}
+
+func (js JobState) String() string {
+	if state, ok := jobState[js]; ok {
+		return state
+	}
+	return fmt.Sprintf("unknown (%d)", int(js))
+}
+
+// Readable descriptions of common printer-state-reasons keywords (RFC 8011, section 5.4.12).
+var printerStateReason = map[string]string{
+	"media-empty":         "out of paper",
+	"media-needed":        "out of paper",
+	"media-low":           "paper low",
+	"media-jam":           "paper jam",
+	"toner-low":           "toner low",
+	"toner-empty":         "toner empty",
+	"marker-supply-low":   "ink or toner low",
+	"marker-supply-empty": "ink or toner empty",
+	"door-open":           "door open",
+	"cover-open":          "cover open",
+	"input-tray-missing":  "input tray missing",
+	"output-area-full":    "output tray full",
+	"offline":             "offline",
+	"paused":              "paused",
+	"shutdown":            "shut down",
+}
+
+type printerStatus struct {
+	name    string
+	state   PrinterState
+	reasons []string
+	message string
+}
+
+func (status printerStatus) String() string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%s: %s", status.name, status.state))
+	for _, reason := range status.reasons {
+		builder.WriteString(", " + reason)
+	}
+	if status.message != "" {
+		builder.WriteString("\n" + status.message)
+	}
+	return builder.String()
+}
+
+type printJob struct {
+	id    int
+	name  string
+	state JobState
+	user  string
+}
+
+func (job printJob) String() string {
+	return fmt.Sprintf("Job %d: %s (%s, %s)", job.id, job.name, job.state, job.user)
+}
+
+func (printer printer) getStatus() (printerStatus, error) {
+	operation := append(printer.operationAttributes(),
+		newIppAttribute(ippTagKeyword, "requested-attributes", "printer-name", "printer-state", "printer-state-reasons", "printer-state-message"),
+	)
+	response, err := printer.do(ippGetPrinterAttributes, []ippGroup{{tag: ippTagOperation, attributes: operation}}, nil)
+	if err != nil {
+		return printerStatus{}, err
+	}
+	groups := response.getGroups(ippTagPrinter)
+	if len(groups) == 0 {
+		return printerStatus{}, fmt.Errorf("printer did not return any attributes")
+	}
+	status := printerStatus{
+		name:    groups[0].getString("printer-name"),
+		state:   PrinterState(groups[0].getInt("printer-state")),
+		message: groups[0].getString("printer-state-message"),
+	}
+	if status.name == "" {
+		status.name = printer.uri
+	}
+	for _, keyword := range groups[0].getStrings("printer-state-reasons") {
+		if keyword == "none" {
+			continue
+		}
+		// Reasons may carry a severity suffix, e.g. toner-low-warning
+		keyword = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(keyword, "-report"), "-warning"), "-error")
+		if reason, ok := printerStateReason[keyword]; ok {
+			keyword = reason
+		}
+		if !slices.Contains(status.reasons, keyword) {
+			status.reasons = append(status.reasons, keyword)
+		}
+	}
+	return status, nil
+}
+
+// Returns the jobs that are not completed yet.
+func (printer printer) getJobs() ([]printJob, error) {
+	operation := append(printer.operationAttributes(),
+		newIppAttribute(ippTagKeyword, "which-jobs", "not-completed"),
+		newIppAttribute(ippTagKeyword, "requested-attributes", "job-id", "job-name", "job-state", "job-originating-user-name"),
+	)
+	response, err := printer.do(ippGetJobs, []ippGroup{{tag: ippTagOperation, attributes: operation}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	jobs := []printJob{}
+	for _, group := range response.getGroups(ippTagJob) {
+		jobs = append(jobs, printJob{
+			id:    group.getInt("job-id"),
+			name:  group.getString("job-name"),
+			state: JobState(group.getInt("job-state")),
+			user:  group.getString("job-originating-user-name"),
+		})
+	}
+	return jobs, nil
+}
+
+func (printer printer) cancelJob(id int) error {
+	fmt.Printf("Canceling job %d on %s\n", id, printer.uri)
+	operation := append(printer.operationAttributes(),
+		newIppAttribute(ippTagInteger, "job-id", id),
+	)
+	_, err := printer.do(ippCancelJob, []ippGroup{{tag: ippTagOperation, attributes: operation}}, nil)
+	return err
+}
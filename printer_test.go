@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Serves a fixed ipp response and records the decoded request.
+func newIppServer(t *testing.T, response ippMessage, request **ippMessage) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decoded, err := decodeIppMessage(r.Body)
+		if err != nil {
+			t.Errorf("cannot decode request: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if request != nil {
+			*request = decoded
+		}
+		// The document follows the attributes
+		io.Copy(io.Discard, r.Body)
+		response.requestId = decoded.requestId
+		encoded, err := response.encode()
+		if err != nil {
+			t.Errorf("cannot encode response: %s", err)
+		}
+		w.Header().Set("Content-Type", "application/ipp")
+		w.Write(encoded)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPrinterPrint(t *testing.T) {
+	var request *ippMessage
+	server := newIppServer(t, ippMessage{
+		code: 0x0000,
+		groups: []ippGroup{
+			{tag: ippTagOperation, attributes: []ippAttribute{newIppAttribute(ippTagCharset, "attributes-charset", "utf-8")}},
+			{tag: ippTagJob, attributes: []ippAttribute{newIppAttribute(ippTagInteger, "job-id", 17)}},
+		},
+	}, &request)
+	printer, err := newPrinter(server.URL + "/ipp/print")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := printer.print(strings.NewReader("%PDF-1.4"), "scan.pdf", PrintOptions{copies: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 17 {
+		t.Errorf("job id = %d, want 17", id)
+	}
+	if request == nil || ippOperation(request.code) != ippPrintJob {
+		t.Fatalf("request = %+v, want Print-Job", request)
+	}
+	if name := request.getGroups(ippTagOperation)[0].getString("job-name"); name != "scan.pdf" {
+		t.Errorf("job-name = %q", name)
+	}
+	if copies := request.getGroups(ippTagJob)[0].getInt("copies"); copies != 2 {
+		t.Errorf("copies = %d", copies)
+	}
+}
+
+func TestPrinterStatusCodeError(t *testing.T) {
+	server := newIppServer(t, ippMessage{
+		// client-error-document-format-not-supported
+		code: 0x040A,
+		groups: []ippGroup{
+			{tag: ippTagOperation, attributes: []ippAttribute{newIppAttribute(ippTagText, "status-message", "format not supported")}},
+		},
+	}, nil)
+	printer, err := newPrinter(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = printer.print(strings.NewReader("%PDF-1.4"), "scan.pdf", PrintOptions{})
+	if err == nil || !strings.Contains(err.Error(), "0x040a") || !strings.Contains(err.Error(), "format not supported") {
+		t.Errorf("err = %v, want the status code and message", err)
+	}
+}
+
+func TestPrinterHttpError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	printer, err := newPrinter(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := printer.getStatus(); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("err = %v, want the http status", err)
+	}
+}
+
+func TestNewPrinter(t *testing.T) {
+	tests := []struct {
+		input    string
+		endpoint string
+		uri      string
+		wantErr  bool
+	}{
+		{input: "ipp://cups/printers/office", endpoint: "http://cups:631/printers/office", uri: "ipp://cups:631/printers/office"},
+		{input: "ipps://printer.local/ipp/print", endpoint: "https://printer.local:631/ipp/print", uri: "ipps://printer.local:631/ipp/print"},
+		{input: "ipp://printer:8631/ipp/print", endpoint: "http://printer:8631/ipp/print", uri: "ipp://printer:8631/ipp/print"},
+		{input: "http://printer/ipp/print", endpoint: "http://printer/ipp/print", uri: "ipp://printer:80/ipp/print"},
+		{input: "https://printer/ipp/print", endpoint: "https://printer/ipp/print", uri: "ipps://printer:443/ipp/print"},
+		{input: "http://cups:631/printers/office", endpoint: "http://cups:631/printers/office", uri: "ipp://cups:631/printers/office"},
+		{input: "lpd://printer/queue", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			printer, err := newPrinter(test.input)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", printer)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if printer.endpoint != test.endpoint || printer.uri != test.uri {
+				t.Errorf("endpoint, uri = %s, %s, want %s, %s", printer.endpoint, printer.uri, test.endpoint, test.uri)
+			}
+		})
+	}
+}
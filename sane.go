@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// Backend for scanners attached to this host, using the scanimage and scanadf tools of SANE.
+type saneBackend struct {
+	deviceName string
+}
+
+func newSaneBackend(deviceName string) *saneBackend {
+	return &saneBackend{
+		deviceName: deviceName,
+	}
+}
+
+// Resolutions offered when the device reports a resolution range instead of a list
+var saneStandardResolutions = []int{75, 100, 150, 200, 300, 600, 1200}
+
+// Returns the options of the device as reported by scanimage -A, e.g. --mode: [Lineart Gray Color] or -x: [0..215.9mm].
+func (backend saneBackend) getOptions() (map[string][]string, error) {
+	output, err := exec.Command("scanimage", "--device-name", backend.deviceName, "--all-options").Output()
+	if err != nil {
+		fmt.Println("Failed to get scanner options " + err.Error())
+		return nil, err
+	}
+	options := map[string][]string{}
+	lines := bufio.NewScanner(bytes.NewReader(output))
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if !strings.HasPrefix(line, "-") {
+			continue
+		}
+		name, values, found := strings.Cut(line, " ")
+		if !found {
+			continue
+		}
+		// Strip the current value or state, e.g. "Gray|Color [Color]"
+		if i := strings.LastIndex(values, " ["); i >= 0 {
+			values = values[:i]
+		}
+		options[name] = strings.Split(values, "|")
+	}
+	return options, nil
+}
+
+// Splits a range like 50..1200dpi (in steps of 1) into its bounds, the unit is kept.
+func splitSaneRange(value string) (string, string, error) {
+	lower, upper, found := strings.Cut(value, "..")
+	if !found {
+		return "", "", fmt.Errorf("%q is not a range", value)
+	}
+	// Truncated output like 0.. has no upper bound
+	fields := strings.Fields(upper)
+	if len(fields) == 0 {
+		return "", "", fmt.Errorf("range %q has no upper bound", value)
+	}
+	return lower, fields[0], nil
+}
+
+// Parses a range option like 0..215.9mm or -100..100% (in steps of 1).
+func parseSaneRange(values []string) (float64, float64, error) {
+	if len(values) == 0 {
+		return 0, 0, fmt.Errorf("no range")
+	}
+	lower, upper, err := splitSaneRange(values[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	lowest, errLowest := strconv.ParseFloat(lower, 64)
+	highest, errHighest := strconv.ParseFloat(strings.TrimRight(upper, "mm%"), 64)
+	if errLowest != nil || errHighest != nil {
+		return 0, 0, fmt.Errorf("invalid range %q", values[0])
+	}
+	return lowest, highest, nil
+}
+
+// Parses the upper limit of a geometry option like 0..215.9mm (in steps of 1).
+func parseSaneLimit(values []string) int {
+	_, upper, err := parseSaneRange(values)
+	if err != nil {
+		return 0
+	}
+	return int(upper)
+}
+
+// Returns the argument for a brightness or contrast option, scaled to the range of the device.
+func saneAdjustment(value int, values []string) (string, bool) {
+	lower, upper, err := parseSaneRange(values)
+	if value == 0 || err != nil {
+		return "", false
+	}
+	normal := 0
+	if lower >= 0 || upper <= 0 {
+		// Ranges without negative values have their neutral value in the middle
+		normal = int(lower+upper) / 2
+	}
+	return strconv.Itoa(scaleAdjustment(clampAdjustment(value), int(lower), normal, int(upper))), true
+}
+
+func parseSaneResolutions(values []string) []int {
+	resolutions := []int{}
+	for _, value := range values {
+		value = strings.TrimSuffix(value, "dpi")
+		if strings.Contains(value, "..") {
+			// Ranges look like 50..1200dpi (in steps of 1)
+			lower, upper, err := splitSaneRange(value)
+			if err != nil {
+				fmt.Printf("Ignoring resolutions: %s\n", err.Error())
+				continue
+			}
+			lowest, errLowest := strconv.Atoi(lower)
+			highest, errHighest := strconv.Atoi(strings.TrimSuffix(upper, "dpi"))
+			if errLowest != nil || errHighest != nil {
+				continue
+			}
+			for _, resolution := range saneStandardResolutions {
+				if resolution >= lowest && resolution <= highest {
+					resolutions = append(resolutions, resolution)
+				}
+			}
+		} else if resolution, err := strconv.Atoi(value); err == nil {
+			resolutions = append(resolutions, resolution)
+		}
+	}
+	return resolutions
+}
+
+// Creates a command that is interrupted when the context is canceled. scanimage and scanadf
+// stop the scanner and eject the sheet on SIGINT, they are killed if they do not exit in time.
+func saneCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = time.Second * 10
+	return cmd
+}
+
+func (backend saneBackend) scan(ctx context.Context, function ScannerFunction, progress scanProgress) (io.ReadCloser, string, error) {
+	fmt.Println("Starting scan")
+	options, err := backend.getOptions()
+	if err != nil {
+		return nil, "", err
+	}
+	resolution := closestResolution(parseSaneResolutions(options["--resolution"]), function.getResolution())
+	args := []string{
+		"--device-name", backend.deviceName,
+		"--mode", string(function.mode),
+		"--resolution", strconv.Itoa(resolution),
+	}
+	if function.duplex || len(options["--source"]) > 0 {
+		source, duplexMode := selectSourceName(function, options["--source"])
+		args = append(args, "--source", source)
+		if duplexMode {
+			args = append(args, "--adf-mode", "Duplex")
+		}
+	}
+	if brightness, ok := saneAdjustment(function.adjustments.brightness, options["--brightness"]); ok {
+		args = append(args, "--brightness", brightness)
+	}
+	if contrast, ok := saneAdjustment(function.adjustments.contrast, options["--contrast"]); ok {
+		args = append(args, "--contrast", contrast)
+	}
+	if function.area.width > 0 && function.area.height > 0 {
+		args = append(args,
+			"-l", strconv.Itoa(function.area.left),
+			"-t", strconv.Itoa(function.area.top),
+			"-x", strconv.Itoa(function.area.width),
+			"-y", strconv.Itoa(function.area.height),
+		)
+	}
+	var pages [][]byte
+	if function.source == adf {
+		pages, err = backend.scanFeeder(ctx, args, progress)
+	} else {
+		var page []byte
+		page, err = saneCommand(ctx, "scanimage", append(args, "--format=tiff")...).Output()
+		pages = [][]byte{page}
+	}
+	if ctx.Err() != nil {
+		return nil, "", ctx.Err()
+	}
+	if err != nil {
+		fmt.Println("Scan failed: " + err.Error())
+		return nil, "", err
+	}
+	readers := []io.Reader{}
+	for _, page := range pages {
+		readers = append(readers, bytes.NewReader(page))
+	}
+	var pdf bytes.Buffer
+	err = api.ImportImages(nil, &pdf, readers, nil, model.NewDefaultConfiguration())
+	if err != nil {
+		fmt.Printf("failed to create pdf: %s\n", err.Error())
+		return nil, "", err
+	}
+	return io.NopCloser(&pdf), fmt.Sprintf("scan_%s.pdf", time.Now().Format("2006-01-02_15-04-05")), nil
+}
+
+// Scans all pages in the feeder. Uses scanadf if it is installed and scanimage in batch mode otherwise.
+func (backend saneBackend) scanFeeder(ctx context.Context, args []string, progress scanProgress) ([][]byte, error) {
+	dir, err := os.MkdirTemp("", "telegram-printer-scanner")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("scanadf"); err == nil {
+		cmd = saneCommand(ctx, "scanadf", append(args, "--output-file", filepath.Join(dir, "page%04d"))...)
+	} else {
+		cmd = saneCommand(ctx, "scanimage", append(args, "--format=tiff", "--batch="+filepath.Join(dir, "page%04d"))...)
+	}
+	stopCounting := countPages(dir, progress)
+	output, scanErr := cmd.CombinedOutput()
+	stopCounting()
+	fmt.Println(string(output))
+	if ctx.Err() != nil {
+		// Pages scanned before the cancellation are discarded
+		return nil, ctx.Err()
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		if scanErr != nil {
+			return nil, scanErr
+		}
+		return nil, fmt.Errorf("scanner returned no pages")
+	}
+	// Running out of paper ends the batch with an error, the pages are still usable
+	pages := [][]byte{}
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		page, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(page, []byte("P")) {
+			// scanadf writes pnm, which pdfcpu cannot import
+			page, err = pnmToPng(page)
+			if err != nil {
+				return nil, err
+			}
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+// Reports the number of pages written to the batch directory every second until the returned function is called.
+// The newest file is still being written, so it is not counted.
+func countPages(dir string, progress scanProgress) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		counted := 0
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				files, err := os.ReadDir(dir)
+				if err == nil && len(files)-1 > counted {
+					counted = len(files) - 1
+					progress(stageScanning, counted)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// Converts binary pbm (P4), pgm (P5) and ppm (P6) images to png.
+func pnmToPng(pnm []byte) ([]byte, error) {
+	reader := bufio.NewReader(bytes.NewReader(pnm))
+	readToken := func() (string, error) {
+		var token strings.Builder
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			switch {
+			case b == '#':
+				if _, err := reader.ReadString('\n'); err != nil {
+					return "", err
+				}
+			case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+				if token.Len() > 0 {
+					return token.String(), nil
+				}
+			default:
+				token.WriteByte(b)
+			}
+		}
+	}
+	readInt := func() (int, error) {
+		token, err := readToken()
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(token)
+	}
+	magic, err := readToken()
+	if err != nil {
+		return nil, err
+	}
+	width, err := readInt()
+	if err != nil {
+		return nil, err
+	}
+	height, err := readInt()
+	if err != nil {
+		return nil, err
+	}
+	maxValue := 1
+	if magic != "P4" {
+		if maxValue, err = readInt(); err != nil {
+			return nil, err
+		}
+	}
+	bytesPerSample := 1
+	if maxValue > 255 {
+		bytesPerSample = 2
+	}
+	readSample := func() (uint8, error) {
+		var value int
+		for range bytesPerSample {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			value = value<<8 | int(b)
+		}
+		return uint8(value * 255 / maxValue), nil
+	}
+
+	var img image.Image
+	switch magic {
+	case "P4":
+		bitmap := image.NewGray(image.Rect(0, 0, width, height))
+		row := make([]byte, (width+7)/8)
+		for y := range height {
+			if _, err := io.ReadFull(reader, row); err != nil {
+				return nil, err
+			}
+			for x := range width {
+				// Set bits are black
+				if row[x/8]&(0x80>>(x%8)) == 0 {
+					bitmap.Pix[y*bitmap.Stride+x] = 255
+				}
+			}
+		}
+		img = bitmap
+	case "P5":
+		grayscale := image.NewGray(image.Rect(0, 0, width, height))
+		for i := range grayscale.Pix {
+			if grayscale.Pix[i], err = readSample(); err != nil {
+				return nil, err
+			}
+		}
+		img = grayscale
+	case "P6":
+		rgb := image.NewRGBA(image.Rect(0, 0, width, height))
+		for i := range rgb.Pix {
+			if i%4 == 3 {
+				rgb.Pix[i] = 255
+				continue
+			}
+			if rgb.Pix[i], err = readSample(); err != nil {
+				return nil, err
+			}
+		}
+		img = rgb
+	default:
+		return nil, fmt.Errorf("unsupported pnm format %s", magic)
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
+
+func (backend saneBackend) listDevices() ([]scannerDevice, error) {
+	output, err := exec.Command("scanimage", "--formatted-device-list", "%d\t%v %m%n").Output()
+	if err != nil {
+		fmt.Println("Failed to list scanners " + err.Error())
+		return nil, err
+	}
+	devices := []scannerDevice{}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		id, name, found := strings.Cut(line, "\t")
+		if found {
+			devices = append(devices, scannerDevice{
+				id:   id,
+				name: name,
+			})
+		}
+	}
+	return devices, nil
+}
+
+func (backend saneBackend) getCapabilities() (scannerCapabilities, error) {
+	capabilities := scannerCapabilities{}
+	options, err := backend.getOptions()
+	if err != nil {
+		return capabilities, err
+	}
+	capabilities.sources, capabilities.duplex = deviceSources(options["--source"], options["--adf-mode"])
+	for _, mode := range []ScannerMode{color, gray, lineart} {
+		if slices.Contains(options["--mode"], string(mode)) {
+			capabilities.modes = append(capabilities.modes, mode)
+		}
+	}
+	capabilities.resolutions = parseSaneResolutions(options["--resolution"])
+	capabilities.width = parseSaneLimit(options["-x"])
+	capabilities.height = parseSaneLimit(options["-y"])
+	capabilities.formats = []ScannerFormat{formatPdf}
+	return capabilities, nil
+}
+
+func (backend saneBackend) getFile(fileName string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("SANE scanners do not store scanned files")
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"golang.org/x/image/tiff"
+)
+
+const saneTestOptions = `
+All options specific to device ` + "`airscan:e0:Office'" + `:
+  Standard:
+    --resolution 75|150|300|600dpi [300]
+        Sets the resolution of the scanned image.
+    --mode Color|Gray|Lineart [Color]
+        Selects the scan mode (e.g., lineart, monochrome, or color).
+    --source Flatbed|ADF|ADF Duplex [Flatbed]
+        Selects the scan source (such as a document-feeder).
+  Geometry:
+    -l 0..215.9mm [0]
+        Top-left x position of scan area.
+    -x 0..215.9mm [215.9]
+        Width of scan-area.
+    -y 0..297.1mm [297.1]
+        Height of scan-area.
+  Enhancement:
+    --brightness -100..100% (in steps of 1) [0]
+        Controls the brightness of the acquired image.
+    --contrast 0..255 [inactive]
+        Controls the contrast of the acquired image.
+`
+
+// Fake scanimage. Options and the device list come from fixtures, scans copy the fixture pages.
+// Every call is appended to $SANE_TEST_LOG.
+const saneTestScanimage = `#!/bin/sh
+echo "scanimage $*" >> "$SANE_TEST_LOG"
+pattern=""
+for arg; do
+	case "$arg" in
+	--all-options) cat "$SANE_TEST_FIXTURES/options.txt"; exit 0 ;;
+	--formatted-device-list) printf 'airscan:e0:Office\tTest Office\nnet:host:epson2\tTest Epson\n'; exit 0 ;;
+	--batch=*) pattern="${arg#--batch=}" ;;
+	esac
+done
+if [ -n "$SANE_TEST_HANG" ]; then
+	exec sleep 30
+fi
+if [ -z "$pattern" ]; then
+	exec cat "$SANE_TEST_FIXTURES/page1.tiff"
+fi
+i=1
+while [ "$i" -le "$SANE_TEST_PAGES" ]; do
+	cp "$SANE_TEST_FIXTURES/page$i.tiff" "$(printf "$pattern" "$i")"
+	i=$((i + 1))
+done
+# Running out of paper ends the batch with status 7
+exit "${SANE_TEST_EXIT:-0}"
+`
+
+// Fake scanadf, writing pnm pages like the real one.
+const saneTestScanadf = `#!/bin/sh
+echo "scanadf $*" >> "$SANE_TEST_LOG"
+pattern=""
+while [ $# -gt 0 ]; do
+	if [ "$1" = "--output-file" ]; then
+		pattern="$2"
+	fi
+	shift
+done
+i=1
+while [ "$i" -le "$SANE_TEST_PAGES" ]; do
+	cp "$SANE_TEST_FIXTURES/page$i.pnm" "$(printf "$pattern" "$i")"
+	i=$((i + 1))
+done
+exit "${SANE_TEST_EXIT:-0}"
+`
+
+// Installs the fake tools on PATH and writes fixture pages of 10, 20 and 30 pixels width.
+// The log of the calls is returned.
+func setupFakeSane(t *testing.T, withScanadf bool) string {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("needs a shell")
+	}
+	bin := t.TempDir()
+	fixtures := t.TempDir()
+	write := func(path string, content []byte, mode os.FileMode) {
+		t.Helper()
+		if err := os.WriteFile(path, content, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(bin, "scanimage"), []byte(saneTestScanimage), 0o755)
+	if withScanadf {
+		write(filepath.Join(bin, "scanadf"), []byte(saneTestScanadf), 0o755)
+	}
+	write(filepath.Join(fixtures, "options.txt"), []byte(saneTestOptions), 0o644)
+	for i := 1; i <= 3; i++ {
+		var page bytes.Buffer
+		if err := tiff.Encode(&page, image.NewGray(image.Rect(0, 0, 10*i, 15)), nil); err != nil {
+			t.Fatal(err)
+		}
+		write(filepath.Join(fixtures, fmt.Sprintf("page%d.tiff", i)), page.Bytes(), 0o644)
+		pnm := fmt.Appendf(nil, "P5\n%d 15\n255\n", 10*i)
+		write(filepath.Join(fixtures, fmt.Sprintf("page%d.pnm", i)), append(pnm, make([]byte, 10*i*15)...), 0o644)
+	}
+	// Only the system directories, so a real scanadf is not picked up
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+"/usr/bin"+string(os.PathListSeparator)+"/bin")
+	if path, err := exec.LookPath("scanadf"); !withScanadf && err == nil {
+		t.Skipf("scanadf is installed at %s", path)
+	}
+	log := filepath.Join(t.TempDir(), "calls.log")
+	t.Setenv("SANE_TEST_LOG", log)
+	t.Setenv("SANE_TEST_FIXTURES", fixtures)
+	t.Setenv("SANE_TEST_PAGES", "3")
+	return log
+}
+
+func readSaneLog(t *testing.T, log string) []string {
+	t.Helper()
+	content, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+// Returns the widths of the pages, which identify the fixtures.
+func pageWidths(t *testing.T, pages [][]byte) []int {
+	t.Helper()
+	widths := []int{}
+	for _, page := range pages {
+		config, _, err := image.DecodeConfig(bytes.NewReader(page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		widths = append(widths, config.Width)
+	}
+	return widths
+}
+
+func TestSaneGetOptions(t *testing.T) {
+	setupFakeSane(t, false)
+	options, err := newSaneBackend("airscan:e0:Office").getOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"--resolution": {"75", "150", "300", "600dpi"},
+		"--mode":       {"Color", "Gray", "Lineart"},
+		"--source":     {"Flatbed", "ADF", "ADF Duplex"},
+		"-l":           {"0..215.9mm"},
+		"-x":           {"0..215.9mm"},
+		"-y":           {"0..297.1mm"},
+		"--brightness": {"-100..100% (in steps of 1)"},
+		"--contrast":   {"0..255"},
+	}
+	if !reflect.DeepEqual(options, want) {
+		t.Errorf("options = %v, want %v", options, want)
+	}
+}
+
+func TestSaneCapabilities(t *testing.T) {
+	setupFakeSane(t, false)
+	capabilities, err := newSaneBackend("airscan:e0:Office").getCapabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(capabilities.sources, []ScannerSource{adf, flatbed}) {
+		t.Errorf("sources = %v", capabilities.sources)
+	}
+	if !reflect.DeepEqual(capabilities.modes, []ScannerMode{color, gray, lineart}) {
+		t.Errorf("modes = %v", capabilities.modes)
+	}
+	if !capabilities.duplex || capabilities.width != 215 || capabilities.height != 297 {
+		t.Errorf("duplex %t, area %dx%d", capabilities.duplex, capabilities.width, capabilities.height)
+	}
+}
+
+func TestSaneListDevices(t *testing.T) {
+	setupFakeSane(t, false)
+	devices, err := newSaneBackend("").listDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scannerDevice{{id: "airscan:e0:Office", name: "Test Office"}, {id: "net:host:epson2", name: "Test Epson"}}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("devices = %v, want %v", devices, want)
+	}
+}
+
+func TestParseSaneResolutions(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   []int
+	}{
+		{values: nil, want: []int{}},
+		{values: []string{"75", "150", "300", "600dpi"}, want: []int{75, 150, 300, 600}},
+		{values: []string{"50..400dpi (in steps of 1)"}, want: []int{75, 100, 150, 200, 300}},
+		{values: []string{"100..1200dpi"}, want: []int{100, 150, 200, 300, 600, 1200}},
+		{values: []string{"auto", "300"}, want: []int{300}},
+		{values: []string{"a..bdpi"}, want: []int{}},
+		{values: []string{"50..", "300"}, want: []int{300}},
+		{values: []string{"50.. "}, want: []int{}},
+	}
+	for _, test := range tests {
+		if resolutions := parseSaneResolutions(test.values); !reflect.DeepEqual(resolutions, test.want) {
+			t.Errorf("parseSaneResolutions(%q) = %v, want %v", test.values, resolutions, test.want)
+		}
+	}
+}
+
+func TestParseSaneRange(t *testing.T) {
+	tests := []struct {
+		values []string
+		lower  float64
+		upper  float64
+		ok     bool
+	}{
+		{values: []string{"0..215.9mm"}, lower: 0, upper: 215.9, ok: true},
+		{values: []string{"-100..100% (in steps of 1)"}, lower: -100, upper: 100, ok: true},
+		{values: []string{"0..255"}, lower: 0, upper: 255, ok: true},
+		{values: []string{"Gray"}},
+		{values: []string{"x..ymm"}},
+		{values: []string{"0.."}},
+		{values: []string{"0.. (in steps of 1)"}},
+		{values: nil},
+	}
+	for _, test := range tests {
+		lower, upper, err := parseSaneRange(test.values)
+		if lower != test.lower || upper != test.upper || (err == nil) != test.ok {
+			t.Errorf("parseSaneRange(%q) = %v, %v, %v, want %v, %v, ok %t", test.values, lower, upper, err, test.lower, test.upper, test.ok)
+		}
+	}
+	if limit := parseSaneLimit([]string{"0..297.1mm"}); limit != 297 {
+		t.Errorf("parseSaneLimit = %d, want 297", limit)
+	}
+}
+
+func TestSaneAdjustment(t *testing.T) {
+	tests := []struct {
+		value  int
+		values []string
+		want   string
+		ok     bool
+	}{
+		{value: 50, values: []string{"-100..100%"}, want: "50", ok: true},
+		{value: -100, values: []string{"-100..100%"}, want: "-100", ok: true},
+		// The neutral value of ranges without negative values is in the middle
+		{value: 100, values: []string{"0..255"}, want: "255", ok: true},
+		{value: -100, values: []string{"0..255"}, want: "0", ok: true},
+		{value: 0, values: []string{"-100..100%"}},
+		{value: 50, values: nil},
+		{value: 50, values: []string{"0.."}},
+	}
+	for _, test := range tests {
+		value, ok := saneAdjustment(test.value, test.values)
+		if value != test.want || ok != test.ok {
+			t.Errorf("saneAdjustment(%d, %q) = %q, %t, want %q, %t", test.value, test.values, value, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestPnmToPng(t *testing.T) {
+	tests := []struct {
+		name string
+		pnm  []byte
+		// expected gray or red values of the pixels, row by row
+		want []uint8
+	}{
+		{
+			name: "P4 bitmap",
+			// Rows are padded to full bytes, set bits are black
+			pnm:  append([]byte("P4\n# comment\n3 2\n"), 0b10100000, 0b01000000),
+			want: []uint8{0, 255, 0, 255, 0, 255},
+		},
+		{
+			name: "P5 graymap",
+			pnm:  append([]byte("P5 2 2 255\n"), 0, 64, 128, 255),
+			want: []uint8{0, 64, 128, 255},
+		},
+		{
+			name: "P5 16 bit graymap",
+			pnm:  append([]byte("P5\n2 1\n65535\n"), 0x00, 0x00, 0xff, 0xff),
+			want: []uint8{0, 255},
+		},
+		{
+			name: "P6 pixmap",
+			pnm:  append([]byte("P6\n2 1\n255\n"), 10, 20, 30, 200, 100, 50),
+			want: []uint8{10, 200},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := pnmToPng(test.pnm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bounds := img.Bounds()
+			values := []uint8{}
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					r, _, _, _ := img.At(x, y).RGBA()
+					values = append(values, uint8(r>>8))
+				}
+			}
+			if !reflect.DeepEqual(values, test.want) {
+				t.Errorf("pixels = %v, want %v", values, test.want)
+			}
+		})
+	}
+	if _, err := pnmToPng([]byte("P3\n1 1\n255\n0 0 0\n")); err == nil {
+		t.Error("expected an error for ascii pnm")
+	}
+	if _, err := pnmToPng([]byte("P5\n2 2\n255\n")); err == nil {
+		t.Error("expected an error for truncated data")
+	}
+}
+
+func TestSaneScanFeederBatch(t *testing.T) {
+	log := setupFakeSane(t, false)
+	t.Setenv("SANE_TEST_EXIT", "7")
+	pages, err := newSaneBackend("airscan:e0:Office").scanFeeder(context.Background(), []string{"--device-name", "airscan:e0:Office"}, func(ScanStage, int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Running out of paper is not an error, the pages are kept in order
+	if widths := pageWidths(t, pages); !reflect.DeepEqual(widths, []int{10, 20, 30}) {
+		t.Errorf("page widths = %v, want [10 20 30]", widths)
+	}
+	calls := readSaneLog(t, log)
+	if len(calls) != 1 || !strings.Contains(calls[0], "--format=tiff --batch=") {
+		t.Errorf("calls = %q, want a scanimage batch", calls)
+	}
+}
+
+func TestSaneScanFeederScanadf(t *testing.T) {
+	log := setupFakeSane(t, true)
+	pages, err := newSaneBackend("airscan:e0:Office").scanFeeder(context.Background(), []string{"--device-name", "airscan:e0:Office"}, func(ScanStage, int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range pages {
+		if !bytes.HasPrefix(page, []byte("\x89PNG")) {
+			t.Fatalf("page was not converted to png")
+		}
+	}
+	if widths := pageWidths(t, pages); !reflect.DeepEqual(widths, []int{10, 20, 30}) {
+		t.Errorf("page widths = %v, want [10 20 30]", widths)
+	}
+	if calls := readSaneLog(t, log); len(calls) != 1 || !strings.HasPrefix(calls[0], "scanadf ") {
+		t.Errorf("calls = %q, want scanadf", calls)
+	}
+}
+
+func TestSaneScanFeederEmpty(t *testing.T) {
+	setupFakeSane(t, false)
+	t.Setenv("SANE_TEST_PAGES", "0")
+	t.Setenv("SANE_TEST_EXIT", "7")
+	_, err := newSaneBackend("airscan:e0:Office").scanFeeder(context.Background(), nil, func(ScanStage, int) {})
+	if err == nil {
+		t.Error("expected an error for an empty feeder")
+	}
+}
+
+func TestSaneScan(t *testing.T) {
+	log := setupFakeSane(t, false)
+	function := ScannerFunction{
+		mode:        gray,
+		source:      adf,
+		duplex:      true,
+		resolution:  400,
+		area:        ScannerArea{left: 5, top: 10, width: 100, height: 150},
+		adjustments: ScannerAdjustments{brightness: 20},
+	}
+	file, _, err := newSaneBackend("airscan:e0:Office").scan(context.Background(), function, func(ScanStage, int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages, err := api.PageCount(bytes.NewReader(pdf), nil); err != nil || pages != 3 {
+		t.Errorf("page count = %d, %v, want 3", pages, err)
+	}
+	calls := readSaneLog(t, log)
+	want := "scanimage --device-name airscan:e0:Office --mode Gray --resolution 300 --source ADF Duplex --brightness 20 -l 5 -t 10 -x 100 -y 150 --format=tiff --batch="
+	if len(calls) != 2 || !strings.HasPrefix(calls[1], want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestSaneScanCancel(t *testing.T) {
+	setupFakeSane(t, false)
+	t.Setenv("SANE_TEST_HANG", "1")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+	started := time.Now()
+	_, _, err := newSaneBackend("airscan:e0:Office").scan(ctx, ScannerFunction{mode: color, source: flatbed}, func(ScanStage, int) {})
+	if err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want the context error", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second*5 {
+		t.Errorf("scan took %s after the cancellation", elapsed)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Queue of the jobs waiting for a scanner. Jobs run one after another in the order they arrived.
+type scanQueue struct {
+	mutex   sync.Mutex
+	busy    bool
+	waiting []*scanTicket
+}
+
+type scanTicket struct {
+	ready chan struct{}
+	// called with the position in the queue whenever it changes and with 0 when the job starts
+	onPosition func(position int)
+	// guarded by the mutex of the queue, -1 once the job left the queue without starting
+	position int
+	// serializes the calls of onPosition, which run without holding the queue
+	notifyMutex sync.Mutex
+	reported    int
+}
+
+func newScanQueue() *scanQueue {
+	return &scanQueue{}
+}
+
+// Blocks until the scanner is free or the context is canceled.
+// Every successful acquire has to be followed by a release.
+func (queue *scanQueue) acquire(ctx context.Context, onPosition func(position int)) error {
+	queue.mutex.Lock()
+	if !queue.busy && len(queue.waiting) == 0 {
+		queue.busy = true
+		queue.mutex.Unlock()
+		return nil
+	}
+	ticket := &scanTicket{
+		ready:      make(chan struct{}),
+		onPosition: onPosition,
+		position:   len(queue.waiting) + 1,
+		reported:   -1,
+	}
+	queue.waiting = append(queue.waiting, ticket)
+	queue.mutex.Unlock()
+	queue.notify([]*scanTicket{ticket})
+	select {
+	case <-ticket.ready:
+		queue.notify([]*scanTicket{ticket})
+		return nil
+	case <-ctx.Done():
+		queue.mutex.Lock()
+		index := slices.Index(queue.waiting, ticket)
+		ticket.position = -1
+		if index < 0 {
+			queue.mutex.Unlock()
+			// The scanner was handed to this job in the meantime, pass it on
+			queue.release()
+			return ctx.Err()
+		}
+		queue.waiting = slices.Delete(queue.waiting, index, index+1)
+		moved := slices.Clone(queue.waiting[index:])
+		for i, waiting := range moved {
+			waiting.position = index + i + 1
+		}
+		queue.mutex.Unlock()
+		// Wait for a report that is still running, none follows once acquire returned
+		ticket.notifyMutex.Lock()
+		ticket.notifyMutex.Unlock()
+		queue.notify(moved)
+		return ctx.Err()
+	}
+}
+
+// Hands the scanner to the next job and tells the others their new position.
+func (queue *scanQueue) release() {
+	queue.mutex.Lock()
+	if len(queue.waiting) == 0 {
+		queue.busy = false
+		queue.mutex.Unlock()
+		return
+	}
+	next := queue.waiting[0]
+	next.position = 0
+	queue.waiting = queue.waiting[1:]
+	for i, ticket := range queue.waiting {
+		ticket.position = i + 1
+	}
+	moved := slices.Clone(queue.waiting)
+	close(next.ready)
+	queue.mutex.Unlock()
+	queue.notify(moved)
+}
+
+// Reports the positions of the tickets. onPosition edits a Telegram message, so it is called without
+// holding the queue. Every call reports the latest position of the ticket, so updates that race cannot
+// arrive out of order.
+func (queue *scanQueue) notify(tickets []*scanTicket) {
+	for _, ticket := range tickets {
+		ticket.notifyMutex.Lock()
+		queue.mutex.Lock()
+		position := ticket.position
+		queue.mutex.Unlock()
+		if position >= 0 && position != ticket.reported {
+			ticket.reported = position
+			ticket.onPosition(position)
+		}
+		ticket.notifyMutex.Unlock()
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Job that waits in the queue and records the reported positions.
+type queuedJob struct {
+	positions chan int
+	done      chan error
+}
+
+func enqueue(t *testing.T, queue *scanQueue, ctx context.Context) *queuedJob {
+	t.Helper()
+	job := &queuedJob{positions: make(chan int, 10), done: make(chan error, 1)}
+	go func() {
+		job.done <- queue.acquire(ctx, func(position int) { job.positions <- position })
+	}()
+	return job
+}
+
+func (job *queuedJob) expectPosition(t *testing.T, want int) {
+	t.Helper()
+	select {
+	case position := <-job.positions:
+		if position != want {
+			t.Errorf("position = %d, want %d", position, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("position %d was not reported", want)
+	}
+}
+
+func (job *queuedJob) expectNoPosition(t *testing.T) {
+	t.Helper()
+	select {
+	case position := <-job.positions:
+		t.Errorf("unexpected position %d", position)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (job *queuedJob) expectDone(t *testing.T, want error) {
+	t.Helper()
+	select {
+	case err := <-job.done:
+		if err != want {
+			t.Errorf("err = %v, want %v", err, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("acquire did not return")
+	}
+}
+
+func TestScanQueuePositions(t *testing.T) {
+	queue := newScanQueue()
+	if err := queue.acquire(context.Background(), func(int) { t.Error("free scanner reported a position") }); err != nil {
+		t.Fatal(err)
+	}
+	first := enqueue(t, queue, context.Background())
+	first.expectPosition(t, 1)
+	second := enqueue(t, queue, context.Background())
+	second.expectPosition(t, 2)
+
+	queue.release()
+	first.expectPosition(t, 0)
+	first.expectDone(t, nil)
+	second.expectPosition(t, 1)
+
+	queue.release()
+	second.expectPosition(t, 0)
+	second.expectDone(t, nil)
+	queue.release()
+	if queue.busy || len(queue.waiting) != 0 {
+		t.Errorf("queue not empty: busy %t, %d waiting", queue.busy, len(queue.waiting))
+	}
+}
+
+func TestScanQueueCancelWhileWaiting(t *testing.T) {
+	queue := newScanQueue()
+	queue.acquire(context.Background(), func(int) {})
+	first := enqueue(t, queue, context.Background())
+	first.expectPosition(t, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := enqueue(t, queue, ctx)
+	canceled.expectPosition(t, 2)
+	last := enqueue(t, queue, context.Background())
+	last.expectPosition(t, 3)
+
+	cancel()
+	canceled.expectDone(t, context.Canceled)
+	last.expectPosition(t, 2)
+	first.expectNoPosition(t)
+	canceled.expectNoPosition(t)
+
+	queue.release()
+	first.expectPosition(t, 0)
+	first.expectDone(t, nil)
+	last.expectPosition(t, 1)
+}
+
+func TestScanQueueCancelRacesWithHandoff(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		queue := newScanQueue()
+		queue.acquire(context.Background(), func(int) {})
+		ctx, cancel := context.WithCancel(context.Background())
+		var gaveUp atomic.Bool
+		done := make(chan error)
+		queued := make(chan struct{})
+		go func() {
+			err := queue.acquire(ctx, func(position int) {
+				if gaveUp.Load() {
+					t.Errorf("position %d reported after the canceled acquire returned", position)
+				}
+				if position == 1 {
+					close(queued)
+				}
+			})
+			gaveUp.Store(err != nil)
+			done <- err
+		}()
+		<-queued
+		go cancel()
+		queue.release()
+		if err := <-done; err == nil {
+			queue.release()
+		}
+		// Whoever ended up with the scanner passed it on, so it is free again
+		acquired := make(chan error)
+		go func() { acquired <- queue.acquire(context.Background(), func(int) {}) }()
+		select {
+		case err := <-acquired:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("scanner was not released in round %d", i)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type ScanStage string
+
+const (
+	stageScanning           ScanStage = "Scanning"
+	stageFetching           ScanStage = "Fetching the file"
+	stageMergingDuplex      ScanStage = "Merging duplex pages"
+	stageRemovingBlankPages ScanStage = "Removing blank pages"
+	stageRecognizingText    ScanStage = "Recognizing text"
+	stageSending            ScanStage = "Sending to Telegram"
+	stageUploading          ScanStage = "Uploading to Paperless"
+	stagePrinting           ScanStage = "Sending to the printer"
+)
+
+var scanStage = map[ScanStage]string{
+	stageScanning:           string(stageScanning),
+	stageFetching:           string(stageFetching),
+	stageMergingDuplex:      string(stageMergingDuplex),
+	stageRemovingBlankPages: string(stageRemovingBlankPages),
+	stageRecognizingText:    string(stageRecognizingText),
+	stageSending:            string(stageSending),
+	stageUploading:          string(stageUploading),
+	stagePrinting:           string(stagePrinting),
+}
+
+func (ss ScanStage) String() string {
+	return scanStage[ss]
+}
+
+// Called by the backends when a scan makes progress, pages is the number of pages received so far or 0 if unknown.
+type scanProgress func(stage ScanStage, pages int)
+
+// How often the elapsed time in the status message is refreshed
+const statusInterval = time.Second * 5
+
+// Status message of a running job, edited whenever the job makes progress.
+// It is updated from the goroutines of the chat, the scan queue and the backends.
+type scanStatus struct {
+	mutex       sync.Mutex
+	bot         *tgbotapi.BotAPI
+	chatId      int64
+	messageId   int
+	scannerName string
+	started     time.Time
+	stage       ScanStage
+	pages       int
+	// position in the scan queue, 0 once the job runs
+	position   int
+	cancelable bool
+	stopped    bool
+	stop       chan struct{}
+}
+
+// Creates the status of the message and refreshes it until it is closed.
+func newScanStatus(bot *tgbotapi.BotAPI, chatId int64, messageId int, scannerName string) *scanStatus {
+	status := &scanStatus{
+		bot:         bot,
+		chatId:      chatId,
+		messageId:   messageId,
+		scannerName: scannerName,
+		started:     time.Now(),
+		stop:        make(chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-status.stop:
+				return
+			case <-ticker.C:
+				status.update(func() {})
+			}
+		}
+	}()
+	return status
+}
+
+func (status *scanStatus) text() string {
+	var builder strings.Builder
+	if status.position > 0 {
+		builder.WriteString(fmt.Sprintf("Waiting for %s, position %d\n", status.scannerName, status.position))
+	} else {
+		builder.WriteString(fmt.Sprintf("Scanner: %s\n", status.scannerName))
+		if status.stage != "" {
+			builder.WriteString(fmt.Sprintf("Stage: %s\n", status.stage))
+		}
+		if status.pages > 0 {
+			builder.WriteString(fmt.Sprintf("Pages received: %d\n", status.pages))
+		}
+	}
+	builder.WriteString(fmt.Sprintf("Elapsed: %s", time.Since(status.started).Round(time.Second)))
+	return builder.String()
+}
+
+// Applies a change and edits the message. Changes after close are ignored, the message belongs to the next state then.
+func (status *scanStatus) update(change func()) {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	if status.stopped {
+		return
+	}
+	change()
+	edit := tgbotapi.NewEditMessageText(status.chatId, status.messageId, status.text())
+	if status.cancelable {
+		keyboard := stringSliceToKeyboard(sliceToStringSlice([]ScanJobAction{cancelScan}))
+		edit.ReplyMarkup = &keyboard
+	}
+	if _, err := status.bot.Send(edit); err != nil {
+		fmt.Printf("Failed to update status: %s\n", err.Error())
+	}
+}
+
+func (status *scanStatus) setPosition(position int) {
+	status.update(func() {
+		status.position = position
+	})
+}
+
+func (status *scanStatus) setStage(stage ScanStage) {
+	status.update(func() {
+		status.stage = stage
+	})
+}
+
+func (status *scanStatus) progress(stage ScanStage, pages int) {
+	status.update(func() {
+		status.stage = stage
+		if pages > 0 {
+			status.pages = pages
+		}
+	})
+}
+
+func (status *scanStatus) setCancelable(cancelable bool) {
+	status.update(func() {
+		status.cancelable = cancelable
+	})
+}
+
+// Stops refreshing the message.
+func (status *scanStatus) close() {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	if !status.stopped {
+		status.stopped = true
+		close(status.stop)
+	}
+}
@@ -1,29 +1,186 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"slices"
 )
 
-var Scanner scanner
-
 type scanner struct {
-	endpoint  string
+	// shown in the chat if several scanners are configured
+	name      string
+	backend   scannerBackend
 	functions []ScannerFunction
-	deviceId  string
+	areas     []ScannerArea
+	// nil if the capabilities could not be read, all functions are offered then
+	capabilities *scannerCapabilities
+	// pages with less ink coverage (in percent) are considered blank
+	blankPageThreshold float64
+	// the device handles one scan at a time, across all chats
+	queue *scanQueue
 }
 
-func newScanner(endpoint string, functions []ScannerFunction, deviceId string) *scanner {
-	return &scanner{
-		endpoint:  endpoint,
-		functions: functions,
-		deviceId:  deviceId,
+// Creates a scanner offering the preset scan areas and the custom ones, which replace presets of the same name.
+func newScanner(name string, backend scannerBackend, functions []ScannerFunction, customAreas []ScannerArea, blankPageThreshold float64) *scanner {
+	scanner := &scanner{
+		name:               name,
+		queue:              newScanQueue(),
+		backend:            backend,
+		functions:          functions,
+		blankPageThreshold: blankPageThreshold,
+	}
+	for _, area := range scannerAreas {
+		if !slices.ContainsFunc(customAreas, func(custom ScannerArea) bool { return custom.name == area.name }) {
+			scanner.areas = append(scanner.areas, area)
+		}
+	}
+	scanner.areas = append(scanner.areas, customAreas...)
+	capabilities, err := backend.getCapabilities()
+	if err != nil {
+		fmt.Printf("Failed to read capabilities of %s, offering all functions: %s\n", name, err.Error())
+		return scanner
 	}
+	fmt.Printf("Capabilities of %s: %+v\n", name, capabilities)
+	scanner.capabilities = &capabilities
+	for _, function := range functions {
+		if !scanner.supports(function) {
+			fmt.Printf("%s does not support %s %s as %s, disabling function for %s\n", name, function.source, function.mode, function.getFormat(), function.target)
+		}
+	}
+	return scanner
+}
+
+func (scanner scanner) String() string {
+	return scanner.name
+}
+
+func (scanner scanner) supports(function ScannerFunction) bool {
+	if scanner.capabilities == nil {
+		return true
+	}
+	if !slices.Contains(scanner.capabilities.formats, function.getFormat()) {
+		return false
+	}
+	for _, filter := range function.filters {
+		if !slices.Contains(scanner.capabilities.filters, filter) {
+			return false
+		}
+	}
+	return slices.Contains(scanner.capabilities.sources, function.source) && slices.Contains(scanner.capabilities.modes, function.mode)
+}
+
+// Scans once the device is free. waiting is called with the position in the queue while the job waits
+// and with 0 when it starts, progress with the stages of the running scan. The scanned file is fetched completely
+// before the next job may start. Canceling the context leaves the queue or aborts the scan and returns the context error.
+// If the device keeps scanning after a cancel, the next job waits until the device is idle.
+func (scanner scanner) scan(ctx context.Context, function ScannerFunction, waiting func(position int), progress scanProgress) (io.ReadCloser, string, error) {
+	if err := scanner.queue.acquire(ctx, waiting); err != nil {
+		return nil, "", err
+	}
+	defer scanner.releaseWhenIdle()
+	progress(stageScanning, 0)
+	function.area = scanner.clampArea(function.area)
+	if function.adjustments.lineart {
+		if scanner.capabilities == nil || slices.Contains(scanner.capabilities.modes, lineart) {
+			function.mode = lineart
+		} else {
+			fmt.Println("Scanner does not support lineart, keeping mode " + string(function.mode))
+		}
+	}
+	file, fileName, err := scanner.backend.scan(ctx, function, progress)
+	if ctx.Err() != nil {
+		fmt.Println("Scan canceled")
+		if file != nil {
+			file.Close()
+		}
+		return nil, "", ctx.Err()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	progress(stageFetching, 0)
+	var fetched bytes.Buffer
+	if _, err := io.Copy(&fetched, file); err != nil {
+		fmt.Printf("failed to fetch %s: %s\n", fileName, err.Error())
+		return nil, "", err
+	}
+	if ctx.Err() != nil {
+		return nil, "", ctx.Err()
+	}
+	return io.NopCloser(&fetched), fileName, nil
+}
+
+func (scanner scanner) supportsPreview() bool {
+	_, ok := scanner.backend.(scannerPreviewer)
+	return ok
+}
+
+func (scanner scanner) preview(ctx context.Context, function ScannerFunction, waiting func(position int)) ([]byte, error) {
+	previewer, ok := scanner.backend.(scannerPreviewer)
+	if !ok {
+		return nil, fmt.Errorf("scanner does not support previews")
+	}
+	if err := scanner.queue.acquire(ctx, waiting); err != nil {
+		return nil, err
+	}
+	defer scanner.releaseWhenIdle()
+	function.area = scanner.clampArea(function.area)
+	image, err := previewer.preview(ctx, function)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return image, err
+}
+
+// Releases the queue once the device finished canceled scans that still run on it, without blocking the caller.
+func (scanner scanner) releaseWhenIdle() {
+	waiter, ok := scanner.backend.(scannerIdleWaiter)
+	if !ok {
+		scanner.queue.release()
+		return
+	}
+	go func() {
+		waiter.waitIdle()
+		scanner.queue.release()
+	}()
+}
+
+// Limits the area to the maximum the device can scan. The full bed is resolved to that maximum.
+func (scanner scanner) clampArea(area ScannerArea) ScannerArea {
+	if scanner.capabilities == nil || scanner.capabilities.width == 0 || scanner.capabilities.height == 0 {
+		return area
+	}
+	if area.width == 0 || area.height == 0 {
+		area.left, area.top = 0, 0
+		area.width, area.height = scanner.capabilities.width, scanner.capabilities.height
+	}
+	area.left = min(area.left, scanner.capabilities.width-1)
+	area.top = min(area.top, scanner.capabilities.height-1)
+	area.width = min(area.width, scanner.capabilities.width-area.left)
+	area.height = min(area.height, scanner.capabilities.height-area.top)
+	return area
+}
+
+func (scanner scanner) supportsDuplex() bool {
+	return scanner.capabilities != nil && scanner.capabilities.duplex
+}
+
+func (scanner scanner) getArea(name string) *ScannerArea {
+	for _, area := range scanner.areas {
+		if area.name == name {
+			return &area
+		}
+	}
+	return nil
 }
 
 func (scanner scanner) getTargets() []ScannerTarget {
 	targets := []ScannerTarget{}
 	for _, function := range scanner.functions {
-		if !slices.Contains(targets, function.target) {
+		if scanner.supports(function) && !slices.Contains(targets, function.target) {
 			targets = append(targets, function.target)
 		}
 	}
@@ -33,7 +190,7 @@ func (scanner scanner) getTargets() []ScannerTarget {
 func (scanner scanner) getSources(target ScannerTarget) []ScannerSource {
 	sources := []ScannerSource{}
 	for _, function := range scanner.functions {
-		if function.target == target {
+		if function.target == target && scanner.supports(function) {
 			if !slices.Contains(sources, function.source) {
 				sources = append(sources, function.source)
 			}
@@ -45,7 +202,7 @@ func (scanner scanner) getSources(target ScannerTarget) []ScannerSource {
 func (scanner scanner) getModes(target ScannerTarget, source ScannerSource) []ScannerMode {
 	modes := []ScannerMode{}
 	for _, function := range scanner.functions {
-		if function.target == target && function.source == source {
+		if function.target == target && function.source == source && scanner.supports(function) {
 			if !slices.Contains(modes, function.mode) {
 				modes = append(modes, function.mode)
 			}
@@ -54,11 +211,41 @@ func (scanner scanner) getModes(target ScannerTarget, source ScannerSource) []Sc
 	return modes
 }
 
-func (scanner scanner) getFunction(target ScannerTarget, source ScannerSource, mode ScannerMode) *ScannerFunction {
+func (scanner scanner) getFormats(target ScannerTarget, source ScannerSource, mode ScannerMode) []ScannerFormat {
+	formats := []ScannerFormat{}
+	for _, function := range scanner.functions {
+		if function.target == target && function.source == source && function.mode == mode && scanner.supports(function) {
+			if !slices.Contains(formats, function.getFormat()) {
+				formats = append(formats, function.getFormat())
+			}
+		}
+	}
+	return formats
+}
+
+func (scanner scanner) getFunction(target ScannerTarget, source ScannerSource, mode ScannerMode, format ScannerFormat) *ScannerFunction {
 	for _, function := range scanner.functions {
-		if (function.target == target || target == "") && (function.source == source || source == "") && (function.mode == mode || mode == "") {
+		if (function.target == target || target == "") && (function.source == source || source == "") && (function.mode == mode || mode == "") && (function.getFormat() == format || format == "") && scanner.supports(function) {
 			return &function
 		}
 	}
 	return nil
 }
+
+// Returns the selectable resolutions the device supports.
+func (scanner scanner) getResolutions() []ScannerResolution {
+	if scanner.capabilities == nil || len(scanner.capabilities.resolutions) == 0 {
+		return scannerResolutions
+	}
+	resolutions := []ScannerResolution{}
+	for _, resolution := range scannerResolutions {
+		if slices.Contains(scanner.capabilities.resolutions, int(resolution)) {
+			resolutions = append(resolutions, resolution)
+		}
+	}
+	if len(resolutions) == 0 {
+		// None of the common resolutions is supported, offer the closest one
+		resolutions = append(resolutions, ScannerResolution(closestResolution(scanner.capabilities.resolutions, int(defaultResolution))))
+	}
+	return resolutions
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// A scanner backend talks to one scanner device, e.g. through scanservjs.
+type scannerBackend interface {
+	// Scans with the given function and returns the scanned file with its name.
+	// Canceling the context aborts the scan and stops the device where the backend supports it.
+	// progress is called when the scan reaches a new stage or receives a page.
+	scan(ctx context.Context, function ScannerFunction, progress scanProgress) (io.ReadCloser, string, error)
+	// Lists all devices that are reachable through the backend.
+	listDevices() ([]scannerDevice, error)
+	// Returns the sources, modes, resolutions and scan area supported by the device.
+	getCapabilities() (scannerCapabilities, error)
+	// Fetches a file created by a scan.
+	getFile(fileName string) (io.ReadCloser, error)
+}
+
+// Implemented by backends that can show a low resolution preview of the bed.
+type scannerPreviewer interface {
+	// Returns a jpeg image of the bed.
+	preview(ctx context.Context, function ScannerFunction) ([]byte, error)
+}
+
+// Implemented by backends whose device keeps scanning after the request was canceled.
+type scannerIdleWaiter interface {
+	// Blocks until the device finished the canceled scans.
+	waitIdle()
+}
+
+type scannerDevice struct {
+	id   string
+	name string
+}
+
+type scannerCapabilities struct {
+	sources     []ScannerSource
+	modes       []ScannerMode
+	resolutions []int
+	// maximum scan area in mm, 0 if unknown
+	width  int
+	height int
+	// whether the feeder scans both sides in one pass
+	duplex bool
+	// output formats and filters the backend can produce
+	formats []ScannerFormat
+	filters []ScannerFilter
+}
+
+// Creates the backend with the given name, e.g. the SCANNER_BACKEND environment variable.
+func newScannerBackend(name string, endpoint string, deviceId string) (scannerBackend, error) {
+	switch name {
+	case "", "scanservjs":
+		return newScanservjsBackend(endpoint, deviceId), nil
+	case "escl":
+		return newEsclBackend(endpoint), nil
+	case "sane":
+		return newSaneBackend(deviceId), nil
+	}
+	return nil, fmt.Errorf("unknown scanner backend %s", name)
+}
+
+// Checks that the backend finds the configured device. An empty id uses the default device of the backend.
+// Scanners in standby may not be listed at all, which is only reported, an unknown id among found devices is an error.
+func checkDevice(backend scannerBackend, deviceId string) error {
+	if _, ok := backend.(*esclBackend); ok || deviceId == "" {
+		// eSCL endpoints address a single device
+		return nil
+	}
+	devices, err := backend.listDevices()
+	if err != nil {
+		fmt.Printf("Cannot list devices to check %s: %s\n", deviceId, err.Error())
+		return nil
+	}
+	if len(devices) == 0 {
+		fmt.Printf("No devices found, cannot check %s\n", deviceId)
+		return nil
+	}
+	available := []string{}
+	for _, device := range devices {
+		if device.id == deviceId {
+			return nil
+		}
+		available = append(available, fmt.Sprintf("%s (%s)", device.id, device.name))
+	}
+	return fmt.Errorf("device %s not found, available devices: %s", deviceId, strings.Join(available, ", "))
+}
+
+// Returns the supported resolution closest to the requested one.
+func closestResolution(supported []int, requested int) int {
+	if len(supported) == 0 || slices.Contains(supported, requested) {
+		return requested
+	}
+	distance := func(resolution int) int {
+		return max(resolution-requested, requested-resolution)
+	}
+	closest := supported[0]
+	for _, resolution := range supported[1:] {
+		if distance(resolution) < distance(closest) {
+			closest = resolution
+		}
+	}
+	return closest
+}
+
+// Finds the device specific name of a source, e.g. "Automatic Document Feeder" for adf.
+// Returns an empty string if the device has no such source.
+func matchSourceName(source ScannerSource, names []string) string {
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if source == flatbed && strings.Contains(lower, "flatbed") {
+			return name
+		}
+		if source == adf && (strings.Contains(lower, "adf") || strings.Contains(lower, "feeder")) && !strings.Contains(lower, "duplex") {
+			return name
+		}
+	}
+	return ""
+}
+
+// Finds the device specific name of a duplex feeder source, e.g. "ADF Duplex".
+func matchDuplexSourceName(names []string) string {
+	for _, name := range names {
+		if strings.Contains(strings.ToLower(name), "duplex") {
+			return name
+		}
+	}
+	return ""
+}
+
+// Returns the device specific source for a scan, falling back to the generic name. Devices either offer
+// a duplex source or an adf mode, duplexMode tells whether the adf mode has to be set to Duplex.
+func selectSourceName(function ScannerFunction, names []string) (name string, duplexMode bool) {
+	if function.duplex {
+		if name := matchDuplexSourceName(names); name != "" {
+			return name, false
+		}
+		duplexMode = true
+	}
+	if name := matchSourceName(function.source, names); name != "" {
+		return name, duplexMode
+	}
+	return string(function.source), duplexMode
+}
+
+// Returns the sources a device offers and whether its feeder scans duplex.
+// Devices without source option only have a flatbed.
+func deviceSources(names []string, adfModes []string) ([]ScannerSource, bool) {
+	var sources []ScannerSource
+	for _, source := range []ScannerSource{adf, flatbed} {
+		if matchSourceName(source, names) != "" {
+			sources = append(sources, source)
+		}
+	}
+	if len(names) == 0 {
+		sources = append(sources, flatbed)
+	}
+	return sources, matchDuplexSourceName(names) != "" || slices.Contains(adfModes, "Duplex")
+}
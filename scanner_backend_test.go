@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Backend with a fixed device list, scanning is not supported.
+type fakeBackend struct {
+	devices []scannerDevice
+	err     error
+}
+
+func (backend fakeBackend) scan(ctx context.Context, function ScannerFunction, progress scanProgress) (io.ReadCloser, string, error) {
+	return nil, "", errors.ErrUnsupported
+}
+
+func (backend fakeBackend) listDevices() ([]scannerDevice, error) {
+	return backend.devices, backend.err
+}
+
+func (backend fakeBackend) getCapabilities() (scannerCapabilities, error) {
+	return scannerCapabilities{}, errors.ErrUnsupported
+}
+
+func (backend fakeBackend) getFile(fileName string) (io.ReadCloser, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func TestCheckDevice(t *testing.T) {
+	devices := []scannerDevice{{id: "airscan:e0:Office", name: "Office"}, {id: "net:host:epson2", name: "Epson"}}
+	tests := []struct {
+		name     string
+		backend  scannerBackend
+		deviceId string
+		wantErr  string
+	}{
+		{name: "found", backend: fakeBackend{devices: devices}, deviceId: "net:host:epson2"},
+		{name: "default device", backend: fakeBackend{devices: devices}, deviceId: ""},
+		{name: "unknown id", backend: fakeBackend{devices: devices}, deviceId: "airscan:e1:Office", wantErr: "airscan:e0:Office (Office), net:host:epson2 (Epson)"},
+		{name: "no devices", backend: fakeBackend{}, deviceId: "airscan:e0:Office"},
+		{name: "listing fails", backend: fakeBackend{err: errors.New("offline")}, deviceId: "airscan:e0:Office"},
+		{name: "escl", backend: newEsclBackend("http://scanner/eSCL"), deviceId: "ignored"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkDevice(test.backend, test.deviceId)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestClosestResolution(t *testing.T) {
+	tests := []struct {
+		supported []int
+		requested int
+		want      int
+	}{
+		{supported: nil, requested: 300, want: 300},
+		{supported: []int{150, 300, 600}, requested: 300, want: 300},
+		{supported: []int{150, 300, 600}, requested: 400, want: 300},
+		{supported: []int{150, 300, 600}, requested: 500, want: 600},
+		{supported: []int{75, 100}, requested: 1200, want: 100},
+	}
+	for _, test := range tests {
+		if got := closestResolution(test.supported, test.requested); got != test.want {
+			t.Errorf("closestResolution(%v, %d) = %d, want %d", test.supported, test.requested, got, test.want)
+		}
+	}
+}
+
+func TestMatchSourceName(t *testing.T) {
+	names := []string{"Flatbed", "ADF Front", "ADF Duplex"}
+	if name := matchSourceName(flatbed, names); name != "Flatbed" {
+		t.Errorf("flatbed = %q", name)
+	}
+	if name := matchSourceName(adf, names); name != "ADF Front" {
+		t.Errorf("adf = %q", name)
+	}
+	if name := matchDuplexSourceName(names); name != "ADF Duplex" {
+		t.Errorf("duplex = %q", name)
+	}
+	if name := matchSourceName(adf, []string{"Flatbed"}); name != "" {
+		t.Errorf("missing adf = %q", name)
+	}
+}
+
+func TestSelectSourceName(t *testing.T) {
+	tests := []struct {
+		name           string
+		function       ScannerFunction
+		names          []string
+		wantName       string
+		wantDuplexMode bool
+	}{
+		{name: "flatbed", function: ScannerFunction{source: flatbed}, names: []string{"Flatbed", "ADF Front"}, wantName: "Flatbed"},
+		{name: "adf", function: ScannerFunction{source: adf}, names: []string{"Flatbed", "ADF Front", "ADF Duplex"}, wantName: "ADF Front"},
+		{name: "duplex source", function: ScannerFunction{source: adf, duplex: true}, names: []string{"Flatbed", "ADF Front", "ADF Duplex"}, wantName: "ADF Duplex"},
+		{name: "duplex adf mode", function: ScannerFunction{source: adf, duplex: true}, names: []string{"Flatbed", "Automatic Document Feeder"}, wantName: "Automatic Document Feeder", wantDuplexMode: true},
+		{name: "unknown source", function: ScannerFunction{source: adf}, names: []string{"Flatbed"}, wantName: "ADF"},
+		{name: "no source option", function: ScannerFunction{source: flatbed}, wantName: "Flatbed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, duplexMode := selectSourceName(test.function, test.names)
+			if name != test.wantName || duplexMode != test.wantDuplexMode {
+				t.Errorf("selectSourceName = %q, %t, want %q, %t", name, duplexMode, test.wantName, test.wantDuplexMode)
+			}
+		})
+	}
+}
+
+func TestDeviceSources(t *testing.T) {
+	tests := []struct {
+		name        string
+		names       []string
+		adfModes    []string
+		wantSources []ScannerSource
+		wantDuplex  bool
+	}{
+		{name: "flatbed and adf", names: []string{"Flatbed", "ADF"}, wantSources: []ScannerSource{adf, flatbed}},
+		{name: "duplex source", names: []string{"ADF Front", "ADF Duplex"}, wantSources: []ScannerSource{adf}, wantDuplex: true},
+		{name: "duplex adf mode", names: []string{"ADF"}, adfModes: []string{"Simplex", "Duplex"}, wantSources: []ScannerSource{adf}, wantDuplex: true},
+		{name: "no source option", wantSources: []ScannerSource{flatbed}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sources, duplex := deviceSources(test.names, test.adfModes)
+			if !reflect.DeepEqual(sources, test.wantSources) || duplex != test.wantDuplex {
+				t.Errorf("deviceSources = %v, %t, want %v, %t", sources, duplex, test.wantSources, test.wantDuplex)
+			}
+		})
+	}
+}
@@ -1,12 +1,10 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"time"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
 type ScannerSource string
@@ -28,13 +26,15 @@ func (ss ScannerSource) String() string {
 type ScannerMode string
 
 const (
-	color ScannerMode = "Color"
-	gray  ScannerMode = "Gray"
+	color   ScannerMode = "Color"
+	gray    ScannerMode = "Gray"
+	lineart ScannerMode = "Lineart"
 )
 
 var scannerMode = map[ScannerMode]string{
-	color: string(color),
-	gray:  string(gray),
+	color:   string(color),
+	gray:    string(gray),
+	lineart: string(lineart),
 }
 
 func (ss ScannerMode) String() string {
@@ -44,195 +44,222 @@ func (ss ScannerMode) String() string {
 type ScannerTarget string
 
 const (
-	telegram  ScannerTarget = "telegram"
-	paperless ScannerTarget = "paperless"
+	telegram      ScannerTarget = "telegram"
+	paperless     ScannerTarget = "paperless"
+	printerTarget ScannerTarget = "printer"
 )
 
 var scannerTarget = map[ScannerTarget]string{
-	telegram:  string(telegram),
-	paperless: string(paperless),
+	telegram:      string(telegram),
+	paperless:     string(paperless),
+	printerTarget: string(printerTarget),
 }
 
 func (ss ScannerTarget) String() string {
 	return scannerTarget[ss]
 }
 
-type ScannerFunction struct {
-	mode   ScannerMode
-	source ScannerSource
-	target ScannerTarget
-}
-
-type scanBody struct {
-	Params struct {
-		DeviceID       string `json:"deviceId"`
-		Top            int    `json:"top"`
-		Left           int    `json:"left"`
-		Width          int    `json:"width"`
-		Height         int    `json:"height"`
-		PageWidth      int    `json:"pageWidth"`
-		PageHeight     int    `json:"pageHeight"`
-		Resolution     int    `json:"resolution"`
-		Mode           string `json:"mode"`
-		Source         string `json:"source"`
-		AdfMode        string `json:"adfMode"`
-		Brightness     int    `json:"brightness"`
-		Contrast       int    `json:"contrast"`
-		DynamicLineart bool   `json:"dynamicLineart"`
-		Ald            string `json:"ald"`
-	} `json:"params"`
-	Filters  []string `json:"filters"`
-	Pipeline string   `json:"pipeline"`
-	Batch    string   `json:"batch"`
-	Index    int      `json:"index"`
-}
-
-func newScanBody(function ScannerFunction, scannerId string) *scanBody {
-	batch := "none"
-	if function.source == adf {
-		batch = "auto"
-	}
-	body := scanBody{
-		Params: struct {
-			DeviceID       string "json:\"deviceId\""
-			Top            int    "json:\"top\""
-			Left           int    "json:\"left\""
-			Width          int    "json:\"width\""
-			Height         int    "json:\"height\""
-			PageWidth      int    "json:\"pageWidth\""
-			PageHeight     int    "json:\"pageHeight\""
-			Resolution     int    "json:\"resolution\""
-			Mode           string "json:\"mode\""
-			Source         string "json:\"source\""
-			AdfMode        string "json:\"adfMode\""
-			Brightness     int    "json:\"brightness\""
-			Contrast       int    "json:\"contrast\""
-			DynamicLineart bool   "json:\"dynamicLineart\""
-			Ald            string "json:\"ald\""
-		}{
-			DeviceID:       scannerId,
-			Top:            0,
-			Left:           0,
-			Width:          215,
-			Height:         297,
-			PageWidth:      215,
-			PageHeight:     297,
-			Resolution:     200,
-			Mode:           string(function.mode),
-			Source:         string(function.source),
-			AdfMode:        "Simplex",
-			Brightness:     0,
-			Contrast:       0,
-			DynamicLineart: false,
-			Ald:            "yes",
-		},
-		Filters:  []string{},
-		Pipeline: "PDF (TIF | @:pipeline.uncompressed)",
-		Batch:    batch,
-		Index:    0,
-	}
-	return &body
-}
-
-type scanResponseBody struct {
-	Image any `json:"image"`
-	Index int `json:"index"`
-	File  struct {
-		Fullname     string    `json:"fullname"`
-		Extension    string    `json:"extension"`
-		LastModified time.Time `json:"lastModified"`
-		Size         int64     `json:"size"`
-		SizeString   string    `json:"sizeString"`
-		IsDirectory  bool      `json:"isDirectory"`
-		Name         string    `json:"name"`
-		Path         string    `json:"path"`
-	} `json:"file"`
-}
-
-func (function ScannerFunction) scan(endpoint string, scannerId string) (io.ReadCloser, string, error) {
-	var scanClientWithTimeout = &http.Client{
-		Timeout: time.Minute * 20,
+// Returns the target shown with the given label, targets may be relabeled in the config file.
+func parseScannerTarget(label string) ScannerTarget {
+	for target, targetLabel := range scannerTarget {
+		if targetLabel == label {
+			return target
+		}
 	}
-	fmt.Println("Starting scan")
-	var err error
-	body := newScanBody(function, scannerId)
-	marshalled, err := json.Marshal(body)
-	if err != nil {
-		fmt.Println("Cannot encode JSON: " + err.Error())
-		return nil, "", err
+	return ScannerTarget(label)
+}
+
+type ScannerFormat string
+
+const (
+	formatPdf           ScannerFormat = "PDF"
+	formatCompressedPdf ScannerFormat = "Compressed PDF"
+	formatOcrPdf        ScannerFormat = "OCR PDF"
+	formatJpg           ScannerFormat = "JPG"
+	formatPng           ScannerFormat = "PNG"
+	formatTif           ScannerFormat = "TIF"
+)
+
+var scannerFormat = map[ScannerFormat]string{
+	formatPdf:           string(formatPdf),
+	formatCompressedPdf: string(formatCompressedPdf),
+	formatOcrPdf:        string(formatOcrPdf),
+	formatJpg:           string(formatJpg),
+	formatPng:           string(formatPng),
+	formatTif:           string(formatTif),
+}
+
+func (sf ScannerFormat) String() string {
+	return scannerFormat[sf]
+}
+
+// Pdf scans can be merged, split and printed, images are delivered as they are.
+func (sf ScannerFormat) isPdf() bool {
+	return sf == formatPdf || sf == formatCompressedPdf || sf == formatOcrPdf
+}
+
+// Image filters applied by the scanner backend after scanning
+type ScannerFilter string
+
+const (
+	filterAutoLevel ScannerFilter = "auto-level"
+	filterThreshold ScannerFilter = "threshold"
+	filterBlur      ScannerFilter = "blur"
+)
+
+var scannerFilter = map[ScannerFilter]string{
+	filterAutoLevel: string(filterAutoLevel),
+	filterThreshold: string(filterThreshold),
+	filterBlur:      string(filterBlur),
+}
+
+func (sf ScannerFilter) String() string {
+	return scannerFilter[sf]
+}
+
+// Brightness and contrast range from -100 to 100, 0 keeps the scanner default
+const (
+	adjustmentStep = 10
+	adjustmentMax  = 100
+)
+
+// Image adjustments chosen in the advanced menu of a chat
+type ScannerAdjustments struct {
+	brightness int
+	contrast   int
+	// scan black and white with a dynamic threshold, overrides the mode
+	lineart bool
+}
+
+func (adjustments ScannerAdjustments) isSet() bool {
+	return adjustments != ScannerAdjustments{}
+}
+
+func (adjustments ScannerAdjustments) String() string {
+	lineart := "off"
+	if adjustments.lineart {
+		lineart = "on"
 	}
-	resp, err := scanClientWithTimeout.Post(endpoint+"/api/v1/scan", "application/json", bytes.NewReader(marshalled))
-	if err != nil {
-		fmt.Println("Post failed: " + err.Error())
-		return nil, "", err
+	return fmt.Sprintf("Brightness: %+d\nContrast: %+d\nLineart: %s", adjustments.brightness, adjustments.contrast, lineart)
+}
+
+// Clamps an adjustment to the supported range.
+func clampAdjustment(value int) int {
+	return max(-adjustmentMax, min(adjustmentMax, value))
+}
+
+// Maps an adjustment to a device range with the given neutral value.
+func scaleAdjustment(value int, lower int, normal int, upper int) int {
+	if value >= 0 {
+		return normal + value*(upper-normal)/adjustmentMax
 	}
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Post failed with status code: " + resp.Status)
-		if resp.StatusCode == http.StatusInternalServerError {
-			fmt.Printf("Trying to reload scanners\n")
-			req, err := http.NewRequest(http.MethodDelete, endpoint+"/api/v1/context", nil)
-			if err != nil {
-				fmt.Println("Could not create delete request for scanners")
-				return nil, "", err
-			}
-			client := &http.Client{}
-			fmt.Printf("Delete scanners\n")
-			resp, err = client.Do(req)
-			if err != nil {
-				fmt.Println("Could not delete scanners " + err.Error())
-				return nil, "", err
-			}
-			if resp.StatusCode != http.StatusOK {
-				fmt.Println("Failed to delete scanners: " + resp.Status)
-				return nil, "", fmt.Errorf("failed to delete scanners: %s", resp.Status)
-			}
-			fmt.Printf("Get scanners\n")
-			resp, err = http.Get(endpoint + "/api/v1/context")
-			if err != nil {
-				fmt.Println("Failed to reload scanners " + err.Error())
-				return nil, "", err
-			}
-			if resp.StatusCode != http.StatusOK {
-				fmt.Println("Could not reload scanners: " + resp.Status)
-				return nil, "", fmt.Errorf("could not reload scanners: %s", resp.Status)
-			}
-			fmt.Printf("Retry scan\n")
-			resp, err = scanClientWithTimeout.Post(endpoint+"/api/v1/scan", "application/json", bytes.NewReader(marshalled))
-			if err != nil {
-				fmt.Println("Post failed: " + err.Error())
-				return nil, "", err
-			}
-			if resp.StatusCode != http.StatusOK {
-				fmt.Println("Post failed with status code: " + resp.Status)
-				return nil, "", fmt.Errorf("post failed with status code: %s", resp.Status)
-			}
-		} else {
-			return nil, "", fmt.Errorf("post failed with status code: %s", resp.Status)
+	return normal + value*(normal-lower)/adjustmentMax
+}
+
+type ScannerResolution int
+
+// Resolution used if none was selected
+const defaultResolution ScannerResolution = 200
+
+var scannerResolutions = []ScannerResolution{150, 200, 300, 600}
+
+func (sr ScannerResolution) String() string {
+	return fmt.Sprintf("%d dpi", int(sr))
+}
+
+func parseScannerResolution(value string) (ScannerResolution, error) {
+	resolution, err := strconv.Atoi(strings.TrimSuffix(value, " dpi"))
+	return ScannerResolution(resolution), err
+}
+
+// Scan area in mm. An area without width and height covers the full bed.
+type ScannerArea struct {
+	name   string
+	left   int
+	top    int
+	width  int
+	height int
+}
+
+var fullBed = ScannerArea{name: "Full bed"}
+
+var scannerAreas = []ScannerArea{
+	{name: "A4", width: 210, height: 297},
+	{name: "Letter", width: 216, height: 279},
+	{name: "Legal", width: 216, height: 356},
+	{name: "A5", width: 148, height: 210},
+	{name: "Business card", width: 85, height: 55},
+	{name: "Receipt", width: 80, height: 297},
+	fullBed,
+}
+
+func (sa ScannerArea) String() string {
+	return sa.name
+}
+
+// WIDTHxHEIGHT with optional +LEFT+TOP offsets, all in mm
+var scannerAreaGeometry = regexp.MustCompile(`^(\d+)x(\d+)(?:\+(\d+)\+(\d+))?$`)
+
+// Parses custom areas like "Photo=100x150;Label=60x40+10+5", where the optional
+// offsets are left and top in mm.
+func parseScannerAreas(value string) ([]ScannerArea, error) {
+	areas := []ScannerArea{}
+	for _, definition := range strings.Split(value, ";") {
+		if strings.TrimSpace(definition) == "" {
+			continue
 		}
+		name, geometry, found := strings.Cut(definition, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid scan area %q, expected name=WIDTHxHEIGHT", definition)
+		}
+		area := ScannerArea{name: strings.TrimSpace(name)}
+		geometry = strings.TrimSpace(geometry)
+		match := scannerAreaGeometry.FindStringSubmatch(geometry)
+		if match == nil {
+			return nil, fmt.Errorf("invalid geometry %q for scan area %s, expected WIDTHxHEIGHT or WIDTHxHEIGHT+LEFT+TOP", geometry, area.name)
+		}
+		area.width, _ = strconv.Atoi(match[1])
+		area.height, _ = strconv.Atoi(match[2])
+		if match[3] != "" {
+			area.left, _ = strconv.Atoi(match[3])
+			area.top, _ = strconv.Atoi(match[4])
+		}
+		if area.width <= 0 || area.height <= 0 {
+			return nil, fmt.Errorf("invalid geometry %q for scan area %s", geometry, area.name)
+		}
+		areas = append(areas, area)
 	}
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Read response failed: " + err.Error())
-		return nil, "", err
-	}
-	var result scanResponseBody
-	err = json.Unmarshal(respBody, &result)
-	fmt.Printf("Result (%s): \n", resp.Status)
-	j, _ := json.MarshalIndent(result, "", "  ")
-	fmt.Println(string(j))
-	if err != nil {
-		fmt.Println("Cannot unmarshal JSON: " + err.Error())
-		return nil, "", err
-	}
-	return function.getScannedFile(result.File.Name, endpoint)
+	return areas, nil
+}
 
+type ScannerFunction struct {
+	mode       ScannerMode
+	source     ScannerSource
+	target     ScannerTarget
+	resolution ScannerResolution
+	area       ScannerArea
+	// scan both sides in one pass, requires a duplex feeder
+	duplex bool
+	// drop feeder pages below the ink coverage threshold of the scanner
+	removeBlankPages bool
+	// add a text layer before sending the scan to telegram
+	ocr bool
+	// output format, pdf if empty
+	format      ScannerFormat
+	filters     []ScannerFilter
+	adjustments ScannerAdjustments
+}
+
+func (function ScannerFunction) getResolution() int {
+	if function.resolution == 0 {
+		return int(defaultResolution)
+	}
+	return int(function.resolution)
 }
 
-func (function ScannerFunction) getScannedFile(fileName string, endpoint string) (io.ReadCloser, string, error) {
-	fmt.Printf("Trying to get file %s\n", fileName)
-	resp, err := http.Get(endpoint + "/api/v1/files/" + fileName)
-	if err == nil {
-		return resp.Body, fileName, nil
+func (function ScannerFunction) getFormat() ScannerFormat {
+	if function.format == "" {
+		return formatPdf
 	}
-	return nil, fileName, err
+	return function.format
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScannerAreas(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []ScannerArea
+		wantErr bool
+	}{
+		{input: "", want: []ScannerArea{}},
+		{input: "Photo=100x150", want: []ScannerArea{{name: "Photo", width: 100, height: 150}}},
+		{
+			input: " Photo = 100x150 ; Label=60x40+10+5;",
+			want:  []ScannerArea{{name: "Photo", width: 100, height: 150}, {name: "Label", width: 60, height: 40, left: 10, top: 5}},
+		},
+		{input: "Z=10x10abc", wantErr: true},
+		{input: "Z=10x10+5", wantErr: true},
+		{input: "Z=10x10+5+5+5", wantErr: true},
+		{input: "Z=10x-10", wantErr: true},
+		{input: "Z=10x10+-5+0", wantErr: true},
+		{input: "Z=0x10", wantErr: true},
+		{input: "Z=x10", wantErr: true},
+		{input: "Z", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			areas, err := parseScannerAreas(test.input)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", areas)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(areas, test.want) {
+				t.Errorf("areas = %v, want %v", areas, test.want)
+			}
+		})
+	}
+}
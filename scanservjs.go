@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backend for the scanservjs REST api, see https://github.com/sbs20/scanservjs.
+type scanservjsBackend struct {
+	endpoint string
+	deviceId string
+	// requests to the device that have not been answered yet, including aborted ones
+	running *sync.WaitGroup
+}
+
+func newScanservjsBackend(endpoint string, deviceId string) *scanservjsBackend {
+	return &scanservjsBackend{
+		endpoint: endpoint,
+		deviceId: deviceId,
+		running:  &sync.WaitGroup{},
+	}
+}
+
+type scanBody struct {
+	Params struct {
+		DeviceID       string `json:"deviceId"`
+		Top            int    `json:"top"`
+		Left           int    `json:"left"`
+		Width          int    `json:"width"`
+		Height         int    `json:"height"`
+		PageWidth      int    `json:"pageWidth"`
+		PageHeight     int    `json:"pageHeight"`
+		Resolution     int    `json:"resolution"`
+		Mode           string `json:"mode"`
+		Source         string `json:"source"`
+		AdfMode        string `json:"adfMode"`
+		Brightness     int    `json:"brightness"`
+		Contrast       int    `json:"contrast"`
+		DynamicLineart bool   `json:"dynamicLineart"`
+		Ald            string `json:"ald,omitempty"`
+	} `json:"params"`
+	Filters  []string `json:"filters"`
+	Pipeline string   `json:"pipeline"`
+	Batch    string   `json:"batch"`
+	Index    int      `json:"index"`
+}
+
+// Pipelines scanservjs offers by default, used if the server does not advertise any
+var scanservjsPipeline = map[ScannerFormat]string{
+	formatPdf:           "PDF (TIF | @:pipeline.uncompressed)",
+	formatCompressedPdf: "PDF (JPG | @:pipeline.high-quality)",
+	formatOcrPdf:        "OCR | PDF (JPG | @:pipeline.high-quality)",
+	formatJpg:           "JPG | @:pipeline.high-quality",
+	formatPng:           "PNG",
+	formatTif:           "TIF | @:pipeline.uncompressed",
+}
+
+// Prefixes identifying the advertised pipelines of a format, e.g. "JPG | @:pipeline.medium-quality"
+var scanservjsPipelinePrefix = map[ScannerFormat]string{
+	formatPdf:           "PDF (TIF",
+	formatCompressedPdf: "PDF (JPG",
+	formatOcrPdf:        "OCR",
+	formatJpg:           "JPG",
+	formatPng:           "PNG",
+	formatTif:           "TIF",
+}
+
+// Returns the pipeline producing the format, preferring the default one if it is advertised.
+// Returns an empty string if the server advertises pipelines but none for the format.
+func matchPipeline(format ScannerFormat, pipelines []string) string {
+	if len(pipelines) == 0 || slices.Contains(pipelines, scanservjsPipeline[format]) {
+		return scanservjsPipeline[format]
+	}
+	for _, pipeline := range pipelines {
+		if strings.HasPrefix(pipeline, scanservjsPipelinePrefix[format]) {
+			return pipeline
+		}
+	}
+	return ""
+}
+
+// Returns the advertised filter, e.g. "@:filter.auto-level" for auto-level.
+func matchFilter(filter ScannerFilter, filters []string) string {
+	if len(filters) == 0 {
+		return "@:filter." + string(filter)
+	}
+	for _, name := range filters {
+		if strings.HasSuffix(name, string(filter)) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Creates the scan request. The geometry of the selected area is used, else the full bed.
+// Resolution, source names and adjustment limits are taken from the device context if available. The resolution falls back to the closest one the device supports.
+func newScanBody(function ScannerFunction, scannerId string, device *contextDevice, pipelines []string, filters []string) *scanBody {
+	batch := "none"
+	if function.source == adf {
+		batch = "auto"
+	}
+	left, top, width, height, resolution := 0, 0, 215, 297, function.getResolution()
+	source := string(function.source)
+	adfMode := "Simplex"
+	ald := ""
+	brightness, contrast := clampAdjustment(function.adjustments.brightness), clampAdjustment(function.adjustments.contrast)
+	if device != nil {
+		brightness = device.getAdjustment("--brightness", function.adjustments.brightness)
+		contrast = device.getAdjustment("--contrast", function.adjustments.contrast)
+		width = device.getLimit("-x", width)
+		height = device.getLimit("-y", height)
+		resolution = closestResolution(device.getResolutions(), resolution)
+		var duplexMode bool
+		source, duplexMode = selectSourceName(function, device.getOptions("--source"))
+		if duplexMode {
+			adfMode = "Duplex"
+		}
+		if _, ok := device.Features["--ald"]; ok {
+			ald = "yes"
+		}
+	}
+	if function.area.width > 0 && function.area.height > 0 {
+		left, top, width, height = function.area.left, function.area.top, function.area.width, function.area.height
+	}
+	pipeline := matchPipeline(function.getFormat(), pipelines)
+	if pipeline == "" {
+		fmt.Printf("No pipeline for %s, scanning as %s\n", function.getFormat(), formatPdf)
+		pipeline = matchPipeline(formatPdf, pipelines)
+	}
+	bodyFilters := []string{}
+	for _, filter := range function.filters {
+		if name := matchFilter(filter, filters); name != "" {
+			bodyFilters = append(bodyFilters, name)
+		}
+	}
+	body := scanBody{
+		Params: struct {
+			DeviceID       string "json:\"deviceId\""
+			Top            int    "json:\"top\""
+			Left           int    "json:\"left\""
+			Width          int    "json:\"width\""
+			Height         int    "json:\"height\""
+			PageWidth      int    "json:\"pageWidth\""
+			PageHeight     int    "json:\"pageHeight\""
+			Resolution     int    "json:\"resolution\""
+			Mode           string "json:\"mode\""
+			Source         string "json:\"source\""
+			AdfMode        string "json:\"adfMode\""
+			Brightness     int    "json:\"brightness\""
+			Contrast       int    "json:\"contrast\""
+			DynamicLineart bool   "json:\"dynamicLineart\""
+			Ald            string "json:\"ald,omitempty\""
+		}{
+			DeviceID:       scannerId,
+			Top:            top,
+			Left:           left,
+			Width:          width,
+			Height:         height,
+			PageWidth:      width,
+			PageHeight:     height,
+			Resolution:     resolution,
+			Mode:           string(function.mode),
+			Source:         source,
+			AdfMode:        adfMode,
+			Brightness:     brightness,
+			Contrast:       contrast,
+			DynamicLineart: function.mode == lineart,
+			Ald:            ald,
+		},
+		Filters:  bodyFilters,
+		Pipeline: pipeline,
+		Batch:    batch,
+		Index:    0,
+	}
+	return &body
+}
+
+type scanResponseBody struct {
+	Image any `json:"image"`
+	Index int `json:"index"`
+	File  struct {
+		Fullname     string    `json:"fullname"`
+		Extension    string    `json:"extension"`
+		LastModified time.Time `json:"lastModified"`
+		Size         int64     `json:"size"`
+		SizeString   string    `json:"sizeString"`
+		IsDirectory  bool      `json:"isDirectory"`
+		Name         string    `json:"name"`
+		Path         string    `json:"path"`
+	} `json:"file"`
+}
+
+type contextFeature struct {
+	Default any       `json:"default"`
+	Options []any     `json:"options"`
+	Limits  []float64 `json:"limits"`
+}
+
+type contextDevice struct {
+	Id       string                    `json:"id"`
+	Name     string                    `json:"name"`
+	Features map[string]contextFeature `json:"features"`
+}
+
+type contextResponseBody struct {
+	Devices   []contextDevice `json:"devices"`
+	Pipelines []string        `json:"pipelines"`
+	Filters   []string        `json:"filters"`
+}
+
+func (serverContext contextResponseBody) getDevice(deviceId string) (*contextDevice, error) {
+	for _, device := range serverContext.Devices {
+		if device.Id == deviceId {
+			return &device, nil
+		}
+	}
+	return nil, fmt.Errorf("device %s not found", deviceId)
+}
+
+func (device contextDevice) getOptions(feature string) []string {
+	options := []string{}
+	for _, option := range device.Features[feature].Options {
+		options = append(options, fmt.Sprint(option))
+	}
+	return options
+}
+
+func (device contextDevice) getResolutions() []int {
+	resolutions := []int{}
+	for _, option := range device.getOptions("--resolution") {
+		if resolution, err := strconv.Atoi(option); err == nil {
+			resolutions = append(resolutions, resolution)
+		}
+	}
+	return resolutions
+}
+
+// Returns the upper limit of a geometry feature like -x, e.g. 215 for limits [0, 215].
+func (device contextDevice) getLimit(feature string, fallback int) int {
+	limits := device.Features[feature].Limits
+	if len(limits) == 2 && limits[1] > 0 {
+		return int(limits[1])
+	}
+	return fallback
+}
+
+// Scales an adjustment to the limits of a feature like --brightness, e.g. [0, 255].
+// Without limits the adjustment is sent as it is.
+func (device contextDevice) getAdjustment(feature string, value int) int {
+	limits := device.Features[feature].Limits
+	if len(limits) != 2 || limits[1] <= limits[0] {
+		return clampAdjustment(value)
+	}
+	lower, upper := int(limits[0]), int(limits[1])
+	normal := 0
+	if lower >= 0 || upper <= 0 {
+		// Ranges without negative values have their neutral value in the middle
+		normal = (lower + upper) / 2
+	}
+	return scaleAdjustment(clampAdjustment(value), lower, normal, upper)
+}
+
+// Posts a json body to an endpoint that uses the device. scanservjs keeps scanning when the request is
+// aborted, so canceling the context returns right away but the request stays open until scanservjs answers.
+func (backend scanservjsBackend) postJson(ctx context.Context, client *http.Client, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	backend.running.Add(1)
+	go func() {
+		defer backend.running.Done()
+		resp, err := client.Do(req)
+		done <- result{resp, err}
+	}()
+	select {
+	case result := <-done:
+		return result.resp, result.err
+	case <-ctx.Done():
+		go func() {
+			if result := <-done; result.resp != nil {
+				result.resp.Body.Close()
+			}
+			fmt.Println("Canceled scan finished on the device")
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Blocks until scanservjs answered all requests, then the device is free again.
+func (backend scanservjsBackend) waitIdle() {
+	backend.running.Wait()
+}
+
+// scanservjs cannot stop a running scan, canceling returns right away while the device keeps scanning, see waitIdle.
+func (backend scanservjsBackend) scan(ctx context.Context, function ScannerFunction, progress scanProgress) (io.ReadCloser, string, error) {
+	var scanClientWithTimeout = &http.Client{
+		Timeout: time.Minute * 20,
+	}
+	fmt.Println("Starting scan")
+	serverContext, device, err := backend.getDevice()
+	pipelines, filters := []string{}, []string{}
+	if serverContext != nil {
+		pipelines, filters = serverContext.Pipelines, serverContext.Filters
+	}
+	if err != nil {
+		fmt.Printf("Failed to get device context, using defaults: %s\n", err.Error())
+	}
+	body := newScanBody(function, backend.deviceId, device, pipelines, filters)
+	marshalled, err := json.Marshal(body)
+	if err != nil {
+		fmt.Println("Cannot encode JSON: " + err.Error())
+		return nil, "", err
+	}
+	resp, err := backend.postJson(ctx, scanClientWithTimeout, backend.endpoint+"/api/v1/scan", marshalled)
+	if err != nil {
+		fmt.Println("Post failed: " + err.Error())
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println("Post failed with status code: " + resp.Status)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			return nil, "", fmt.Errorf("post failed with status code: %s", resp.Status)
+		}
+		if err := backend.reloadScanners(); err != nil {
+			return nil, "", err
+		}
+		fmt.Printf("Retry scan\n")
+		resp, err = backend.postJson(ctx, scanClientWithTimeout, backend.endpoint+"/api/v1/scan", marshalled)
+		if err != nil {
+			fmt.Println("Post failed: " + err.Error())
+			return nil, "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Println("Post failed with status code: " + resp.Status)
+			resp.Body.Close()
+			return nil, "", fmt.Errorf("post failed with status code: %s", resp.Status)
+		}
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Println("Read response failed: " + err.Error())
+		return nil, "", err
+	}
+	var result scanResponseBody
+	err = json.Unmarshal(respBody, &result)
+	fmt.Printf("Result (%s): \n", resp.Status)
+	j, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(j))
+	if err != nil {
+		fmt.Println("Cannot unmarshal JSON: " + err.Error())
+		return nil, "", err
+	}
+	progress(stageFetching, 0)
+	file, err := backend.getFile(result.File.Name)
+	return file, result.File.Name, err
+}
+
+// Scanservjs answers with 500 if the scanner went away, e.g. after standby.
+// Deleting the context makes it detect the scanners again.
+func (backend scanservjsBackend) reloadScanners() error {
+	fmt.Printf("Trying to reload scanners\n")
+	req, err := http.NewRequest(http.MethodDelete, backend.endpoint+"/api/v1/context", nil)
+	if err != nil {
+		fmt.Println("Could not create delete request for scanners")
+		return err
+	}
+	client := &http.Client{}
+	fmt.Printf("Delete scanners\n")
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Println("Could not delete scanners " + err.Error())
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println("Failed to delete scanners: " + resp.Status)
+		return fmt.Errorf("failed to delete scanners: %s", resp.Status)
+	}
+	fmt.Printf("Get scanners\n")
+	_, err = backend.getContext()
+	return err
+}
+
+func (backend scanservjsBackend) getContext() (*contextResponseBody, error) {
+	resp, err := http.Get(backend.endpoint + "/api/v1/context")
+	if err != nil {
+		fmt.Println("Failed to reload scanners " + err.Error())
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println("Could not reload scanners: " + resp.Status)
+		return nil, fmt.Errorf("could not reload scanners: %s", resp.Status)
+	}
+	var serverContext contextResponseBody
+	if err := json.NewDecoder(resp.Body).Decode(&serverContext); err != nil {
+		fmt.Println("Cannot unmarshal JSON: " + err.Error())
+		return nil, err
+	}
+	return &serverContext, nil
+}
+
+// Returns the context of the server and the configured device in it.
+func (backend scanservjsBackend) getDevice() (*contextResponseBody, *contextDevice, error) {
+	serverContext, err := backend.getContext()
+	if err != nil {
+		return nil, nil, err
+	}
+	device, err := serverContext.getDevice(backend.deviceId)
+	return serverContext, device, err
+}
+
+func (backend scanservjsBackend) listDevices() ([]scannerDevice, error) {
+	serverContext, err := backend.getContext()
+	if err != nil {
+		return nil, err
+	}
+	devices := []scannerDevice{}
+	for _, device := range serverContext.Devices {
+		devices = append(devices, scannerDevice{
+			id:   device.Id,
+			name: device.Name,
+		})
+	}
+	return devices, nil
+}
+
+func (backend scanservjsBackend) getCapabilities() (scannerCapabilities, error) {
+	capabilities := scannerCapabilities{}
+	serverContext, device, err := backend.getDevice()
+	if err != nil {
+		return capabilities, err
+	}
+	for _, format := range []ScannerFormat{formatPdf, formatCompressedPdf, formatOcrPdf, formatJpg, formatPng, formatTif} {
+		if matchPipeline(format, serverContext.Pipelines) != "" {
+			capabilities.formats = append(capabilities.formats, format)
+		}
+	}
+	for _, filter := range []ScannerFilter{filterAutoLevel, filterThreshold, filterBlur} {
+		if matchFilter(filter, serverContext.Filters) != "" {
+			capabilities.filters = append(capabilities.filters, filter)
+		}
+	}
+	capabilities.sources, capabilities.duplex = deviceSources(device.getOptions("--source"), device.getOptions("--adf-mode"))
+	for _, option := range device.getOptions("--mode") {
+		capabilities.modes = append(capabilities.modes, ScannerMode(option))
+	}
+	capabilities.resolutions = device.getResolutions()
+	capabilities.width = device.getLimit("-x", 0)
+	capabilities.height = device.getLimit("-y", 0)
+	return capabilities, nil
+}
+
+type previewResponseBody struct {
+	Content string `json:"content"`
+}
+
+func (backend scanservjsBackend) preview(ctx context.Context, function ScannerFunction) ([]byte, error) {
+	fmt.Println("Starting preview")
+	_, device, err := backend.getDevice()
+	if err != nil {
+		fmt.Printf("Failed to get device context, using defaults: %s\n", err.Error())
+	}
+	// The preview is always a jpeg, pipeline and filters are not used
+	marshalled, err := json.Marshal(newScanBody(function, backend.deviceId, device, nil, nil))
+	if err != nil {
+		fmt.Println("Cannot encode JSON: " + err.Error())
+		return nil, err
+	}
+	client := &http.Client{
+		Timeout: time.Minute * 5,
+	}
+	// Creating the preview scans the bed, reading it converts the result to jpeg
+	resp, err := backend.postJson(ctx, client, backend.endpoint+"/api/v1/preview", marshalled)
+	if err != nil {
+		fmt.Println("Post failed: " + err.Error())
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println("Post failed with status code: " + resp.Status)
+		return nil, fmt.Errorf("preview failed with status code: %s", resp.Status)
+	}
+	resp, err = client.Get(backend.endpoint + "/api/v1/preview")
+	if err != nil {
+		fmt.Println("Get preview failed: " + err.Error())
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get preview failed with status code: %s", resp.Status)
+	}
+	var result previewResponseBody
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		fmt.Println("Cannot unmarshal JSON: " + err.Error())
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(result.Content)
+}
+
+func (backend scanservjsBackend) getFile(fileName string) (io.ReadCloser, error) {
+	fmt.Printf("Trying to get file %s\n", fileName)
+	resp, err := http.Get(backend.endpoint + "/api/v1/files/" + fileName)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("get file failed with status code: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
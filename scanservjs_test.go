@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScanservjsCancelHoldsQueue(t *testing.T) {
+	started, finish := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	// The device keeps scanning after the request was aborted, the answer comes once it is done
+	mux.HandleFunc("POST /api/v1/scan", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	defer close(finish)
+
+	scanner := scanner{backend: newScanservjsBackend(server.URL, ""), queue: newScanQueue()}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	done := make(chan error)
+	go func() {
+		_, _, err := scanner.scan(ctx, ScannerFunction{mode: color, source: flatbed}, func(int) {}, func(ScanStage, int) {})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan was not canceled")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		scanner.queue.acquire(context.Background(), func(int) {})
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("next job started while the device was scanning")
+	case <-time.After(200 * time.Millisecond):
+	}
+	finish <- struct{}{}
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queue was not released once the device was idle")
+	}
+	scanner.queue.release()
+}
+
+func TestScanservjsAdjustments(t *testing.T) {
+	adjustments := ScannerAdjustments{brightness: 50, contrast: -100}
+	tests := []struct {
+		name           string
+		device         *contextDevice
+		wantBrightness int
+		wantContrast   int
+	}{
+		{name: "no device context", wantBrightness: 50, wantContrast: -100},
+		{name: "without limits", device: &contextDevice{}, wantBrightness: 50, wantContrast: -100},
+		{
+			name: "symmetric limits",
+			device: &contextDevice{Features: map[string]contextFeature{
+				"--brightness": {Limits: []float64{-50, 50}},
+				"--contrast":   {Limits: []float64{-100, 100}},
+			}},
+			wantBrightness: 25, wantContrast: -100,
+		},
+		{
+			name: "positive limits",
+			device: &contextDevice{Features: map[string]contextFeature{
+				"--brightness": {Limits: []float64{0, 254}},
+				"--contrast":   {Limits: []float64{0, 254}},
+			}},
+			wantBrightness: 190, wantContrast: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := newScanBody(ScannerFunction{mode: color, source: flatbed, adjustments: adjustments}, "", test.device, nil, nil)
+			if body.Params.Brightness != test.wantBrightness || body.Params.Contrast != test.wantContrast {
+				t.Errorf("brightness %d, contrast %d, want %d and %d", body.Params.Brightness, body.Params.Contrast, test.wantBrightness, test.wantContrast)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"slices"
+	"sync"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -15,7 +16,11 @@ type telegramBot struct {
 	paperlessToken    string
 	bot               *tgbotapi.BotAPI
 	chats             []*telegramChat
-	scanner           *scanner
+	chatsMutex        sync.Mutex
+	scanners          []*scanner
+	printer           *printer
+	converter         *converter
+	ocr               *ocrEngine
 }
 
 func stringSliceToKeyboard(values []string) tgbotapi.InlineKeyboardMarkup {
@@ -35,20 +40,23 @@ func stringMatrixToKeyboard(values [][]string) tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(keyboardRows...)
 }
 
-func newTelegramBot(allowedUserIds []int64, token string, scanner *scanner, paperlessEndpoint string, paperlessToken string) (*telegramBot, error) {
+func newTelegramBot(allowedUserIds []int64, token string, scanners []*scanner, printer *printer, converter *converter, ocr *ocrEngine, paperlessEndpoint string, paperlessToken string) (*telegramBot, error) {
 	var err error
-	bot := telegramBot{
+	bot := &telegramBot{
 		allowedUserIds:    allowedUserIds,
 		token:             token,
-		scanner:           scanner,
+		scanners:          scanners,
+		printer:           printer,
+		converter:         converter,
+		ocr:               ocr,
 		paperlessEndpoint: paperlessEndpoint,
 		paperlessToken:    paperlessToken,
 	}
 	err = bot.initTelegramBot()
-	return &bot, err
+	return bot, err
 }
 
-func (bot telegramBot) initTelegramBot() error {
+func (bot *telegramBot) initTelegramBot() error {
 	var err error
 	bot.bot, err = tgbotapi.NewBotAPI(bot.token)
 	if err == nil {
@@ -57,7 +65,8 @@ func (bot telegramBot) initTelegramBot() error {
 	return err
 }
 
-func (bot telegramBot) run() {
+// Receives updates and hands them to the goroutines of the chats, so a running scan does not block other chats.
+func (bot *telegramBot) run() {
 	bot.bot.Debug = true
 
 	log.Printf("Authorized on account %s", bot.bot.Self.UserName)
@@ -73,30 +82,30 @@ func (bot telegramBot) run() {
 		fmt.Printf("Received update from %d\n", userId)
 		if slices.Contains(bot.allowedUserIds, userId) {
 			fmt.Println("Message from allowed chat")
-			chat := bot.getChat(chatId)
-			if chat == nil {
-				bot.chats = append(bot.chats, newChat(chatId, bot, bot.scanner, bot.paperlessEndpoint, bot.paperlessToken))
-				chat = bot.getChat(chatId)
-			}
-			// Check if we've gotten a message update.
-			if update.Message != nil {
-				fmt.Printf("Update is message\n")
-				// Send the message.
-				if chat != nil {
-					chat.handleMessage(update.Message)
-				}
-
-			} else if update.CallbackQuery != nil {
-				fmt.Printf("Update is callback\n")
-				if chat != nil {
-					chat.handleCallbackQuery(update.CallbackQuery)
-				}
-				// Respond to the callback query, telling Telegram to show the user
-				// a message with the data received.
+			chat := bot.getOrCreateChat(chatId)
+			if update.CallbackQuery != nil {
+				// Respond to the callback query right away, telling Telegram to show the user
+				// a message with the data received, even if the chat is still busy.
 				callback := tgbotapi.NewCallback(update.CallbackQuery.ID, update.CallbackQuery.Data)
 				if _, err := bot.bot.Request(callback); err != nil {
 					fmt.Println(err.Error())
 				}
+				if ScanJobAction(update.CallbackQuery.Data) == cancelScan {
+					// The chat is busy with the scan, cancel it from here
+					if !chat.cancelRunningScan() {
+						fmt.Printf("Chat %d has no running scan\n", chatId)
+					}
+					continue
+				}
+			}
+			select {
+			case chat.updates <- update:
+			default:
+				// Blocking here would stall all other chats, tell the user instead
+				fmt.Printf("Chat %d has too many pending updates, dropping update\n", chatId)
+				if _, err := bot.bot.Send(tgbotapi.NewMessage(chatId, "Busy, please retry once the current job is done")); err != nil {
+					fmt.Println(err.Error())
+				}
 			}
 		}
 	}
@@ -113,11 +122,17 @@ func getUserAndChatId(update tgbotapi.Update) (user int64, chat int64) {
 
 }
 
-func (bot telegramBot) getChat(chatId int64) *telegramChat {
+// Returns the chat with the id, creating it and starting its goroutine on the first update.
+func (bot *telegramBot) getOrCreateChat(chatId int64) *telegramChat {
+	bot.chatsMutex.Lock()
+	defer bot.chatsMutex.Unlock()
 	for _, chat := range bot.chats {
 		if chat.id == chatId {
 			return chat
 		}
 	}
-	return nil
+	chat := newChat(chatId, bot, bot.scanners, bot.printer, bot.converter, bot.ocr, bot.paperlessEndpoint, bot.paperlessToken)
+	bot.chats = append(bot.chats, chat)
+	go chat.run()
+	return chat
 }
@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
@@ -18,25 +22,53 @@ type ChatState int
 const (
 	stateInit            ChatState = iota
 	stateUseLast         ChatState = iota
+	stateScanner         ChatState = iota
 	stateTarget          ChatState = iota
 	stateSource          ChatState = iota
 	stateDuplex          ChatState = iota
 	stateMode            ChatState = iota
+	stateFormat          ChatState = iota
+	stateResolution      ChatState = iota
+	stateArea            ChatState = iota
 	stateScanDuplexFront ChatState = iota
 	stateScanDuplexRear  ChatState = iota
 	stateScanSimple      ChatState = iota
+	stateDuplexMismatch  ChatState = iota
+	stateBatch           ChatState = iota
+	statePreview         ChatState = iota
+	stateAdvanced        ChatState = iota
+	statePrintUseLast    ChatState = iota
+	statePrintCopies     ChatState = iota
+	statePrintPageRanges ChatState = iota
+	statePrintSides      ChatState = iota
+	statePrintColor      ChatState = iota
+	statePrintMedia      ChatState = iota
 )
 
 var chatState = map[ChatState]string{
 	stateInit:            "stateInit",
 	stateUseLast:         "stateUseLast",
+	stateScanner:         "stateScanner",
 	stateTarget:          "stateTarget",
 	stateSource:          "stateSource",
 	stateDuplex:          "stateDuplex",
 	stateMode:            "stateMode",
+	stateFormat:          "stateFormat",
+	stateResolution:      "stateResolution",
+	stateArea:            "stateArea",
 	stateScanDuplexFront: "stateScanDuplexFront",
 	stateScanDuplexRear:  "stateScanDuplexRear",
 	stateScanSimple:      "stateScanSimple",
+	stateDuplexMismatch:  "stateDuplexMismatch",
+	stateBatch:           "stateBatch",
+	statePreview:         "statePreview",
+	stateAdvanced:        "stateAdvanced",
+	statePrintUseLast:    "statePrintUseLast",
+	statePrintCopies:     "statePrintCopies",
+	statePrintPageRanges: "statePrintPageRanges",
+	statePrintSides:      "statePrintSides",
+	statePrintColor:      "statePrintColor",
+	statePrintMedia:      "statePrintMedia",
 }
 
 func (cs ChatState) String() string {
@@ -46,47 +78,200 @@ func (cs ChatState) String() string {
 type Decision string
 
 const (
-	yes Decision = "Yes"
-	no  Decision = "No"
+	yes       Decision = "Yes"
+	no        Decision = "No"
+	yesRotate Decision = "Yes, rotate 180°"
 )
 
 var decision = map[Decision]string{
-	yes: string(yes),
-	no:  string(no),
+	yes:       string(yes),
+	no:        string(no),
+	yesRotate: string(yesRotate),
 }
 
 func (d Decision) String() string {
 	return decision[d]
 }
 
+// Ways to continue when front and rear pass of a manual duplex scan differ in page count
+type DuplexMismatchAction string
+
+const (
+	retryRear      DuplexMismatchAction = "Retry rear pass"
+	appendUnpaired DuplexMismatchAction = "Append rear pages"
+	keepFronts     DuplexMismatchAction = "Keep fronts only"
+)
+
+var duplexMismatchAction = map[DuplexMismatchAction]string{
+	retryRear:      string(retryRear),
+	appendUnpaired: string(appendUnpaired),
+	keepFronts:     string(keepFronts),
+}
+
+func (dma DuplexMismatchAction) String() string {
+	return duplexMismatchAction[dma]
+}
+
+// Actions between the pages of a flatbed batch
+type BatchAction string
+
+const (
+	scanNextPage    BatchAction = "Scan next page"
+	finishBatch     BatchAction = "Finish"
+	discardLastPage BatchAction = "Discard last"
+)
+
+var batchAction = map[BatchAction]string{
+	scanNextPage:    string(scanNextPage),
+	finishBatch:     string(finishBatch),
+	discardLastPage: string(discardLastPage),
+}
+
+func (ba BatchAction) String() string {
+	return batchAction[ba]
+}
+
+// Actions requesting a scan preview and offered below it
+type PreviewAction string
+
+const (
+	requestPreview PreviewAction = "Preview"
+	previewScan    PreviewAction = "Scan"
+	previewAdjust  PreviewAction = "Adjust"
+	previewCancel  PreviewAction = "Cancel"
+)
+
+var previewAction = map[PreviewAction]string{
+	requestPreview: string(requestPreview),
+	previewScan:    string(previewScan),
+	previewAdjust:  string(previewAdjust),
+	previewCancel:  string(previewCancel),
+}
+
+func (pa PreviewAction) String() string {
+	return previewAction[pa]
+}
+
+// Buttons opening the advanced menu and within it
+type AdvancedAction string
+
+const (
+	openAdvanced   AdvancedAction = "Advanced"
+	brightnessDown AdvancedAction = "Brightness −"
+	brightnessUp   AdvancedAction = "Brightness +"
+	contrastDown   AdvancedAction = "Contrast −"
+	contrastUp     AdvancedAction = "Contrast +"
+	toggleLineart  AdvancedAction = "Toggle lineart"
+	resetAdvanced  AdvancedAction = "Reset"
+	closeAdvanced  AdvancedAction = "Done"
+)
+
+var advancedAction = map[AdvancedAction]string{
+	openAdvanced:   string(openAdvanced),
+	brightnessDown: string(brightnessDown),
+	brightnessUp:   string(brightnessUp),
+	contrastDown:   string(contrastDown),
+	contrastUp:     string(contrastUp),
+	toggleLineart:  string(toggleLineart),
+	resetAdvanced:  string(resetAdvanced),
+	closeAdvanced:  string(closeAdvanced),
+}
+
+func (aa AdvancedAction) String() string {
+	return advancedAction[aa]
+}
+
+// Buttons shown while a scan job runs
+type ScanJobAction string
+
+const (
+	cancelScan ScanJobAction = "Cancel scan"
+)
+
+var scanJobAction = map[ScanJobAction]string{
+	cancelScan: string(cancelScan),
+}
+
+func (sja ScanJobAction) String() string {
+	return scanJobAction[sja]
+}
+
+// Chat override for the blank page removal of the scanner functions
+type BlankPageRemoval string
+
+const (
+	blankPagesDefault BlankPageRemoval = "default"
+	blankPagesOn      BlankPageRemoval = "on"
+	blankPagesOff     BlankPageRemoval = "off"
+)
+
+var blankPageRemoval = map[BlankPageRemoval]string{
+	blankPagesDefault: string(blankPagesDefault),
+	blankPagesOn:      string(blankPagesOn),
+	blankPagesOff:     string(blankPagesOff),
+}
+
+func (bpr BlankPageRemoval) String() string {
+	return blankPageRemoval[bpr]
+}
+
 type telegramChat struct {
-	id                int64
-	bot               telegramBot
-	scanner           *scanner
-	state             ChatState
-	paperlessEndpoint string
-	paperlessToken    string
-	currentTarget     ScannerTarget
-	currentSource     ScannerSource
-	currentMode       ScannerMode
-	currentDuplex     Decision
-	currentMessage    tgbotapi.Message
-	currentFunction   ScannerFunction
-	duplexFrontFile   io.ReadSeeker
-}
-
-func newChat(id int64, bot telegramBot, scanner *scanner, paperlessEndpoint string, paperlessToken string) *telegramChat {
+	id                  int64
+	bot                 *telegramBot
+	updates             chan tgbotapi.Update
+	scanners            []*scanner
+	scanner             *scanner
+	printer             *printer
+	converter           *converter
+	ocr                 *ocrEngine
+	state               ChatState
+	paperlessEndpoint   string
+	paperlessToken      string
+	currentTarget       ScannerTarget
+	currentSource       ScannerSource
+	currentMode         ScannerMode
+	currentFormat       ScannerFormat
+	currentResolution   ScannerResolution
+	currentArea         ScannerArea
+	currentAdjustments  ScannerAdjustments
+	currentDuplex       Decision
+	currentMessage      tgbotapi.Message
+	currentFunction     ScannerFunction
+	duplexFrontFile     io.ReadSeeker
+	duplexFrontPages    []io.ReadSeeker
+	duplexRearPages     []io.ReadSeeker
+	duplexFileName      string
+	rotateRear          bool
+	batchPages          []io.ReadSeeker
+	batchFileName       string
+	currentPrintOptions PrintOptions
+	printFile           io.ReadSeeker
+	printFileName       string
+	blankPageRemoval    BlankPageRemoval
+	scanMutex           sync.Mutex
+	scanCancel          context.CancelFunc
+	// progress of the running job shown in the current message, nil if there is none
+	status *scanStatus
+}
+
+func newChat(id int64, bot *telegramBot, scanners []*scanner, printer *printer, converter *converter, ocr *ocrEngine, paperlessEndpoint string, paperlessToken string) *telegramChat {
 	return &telegramChat{
 		id:                id,
 		bot:               bot,
-		scanner:           scanner,
+		updates:           make(chan tgbotapi.Update, chatUpdateBuffer),
+		scanners:          scanners,
+		scanner:           scanners[0],
+		printer:           printer,
+		converter:         converter,
+		ocr:               ocr,
 		state:             stateInit,
+		blankPageRemoval:  blankPagesDefault,
 		paperlessEndpoint: paperlessEndpoint,
 		paperlessToken:    paperlessToken,
 	}
 }
 
-func (chat telegramChat) sendFile(file io.ReadCloser, fileName string) error {
+func (chat *telegramChat) sendFile(file io.ReadCloser, fileName string) error {
 	tgFile := tgbotapi.FileReader{
 		Name:   fileName,
 		Reader: file,
@@ -99,6 +284,30 @@ func (chat telegramChat) sendFile(file io.ReadCloser, fileName string) error {
 	return err
 }
 
+func (chat *telegramChat) sendText(text string) {
+	_, err := chat.bot.bot.Send(tgbotapi.NewMessage(chat.id, text))
+	if err != nil {
+		fmt.Printf("Failed to send message: %s\n", err.Error())
+	}
+}
+
+// Downloads a file that was sent to the bot.
+func (chat *telegramChat) downloadFile(fileId string) (io.ReadCloser, error) {
+	url, err := chat.bot.bot.GetFileDirectURL(fileId)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download failed with status code: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
 // Converts a slice of any type that implements fmt.Stringer to a slice of strings.
 func sliceToStringSlice[T fmt.Stringer](input []T) []string {
 	output := make([]string, 0, len(input))
@@ -108,30 +317,324 @@ func sliceToStringSlice[T fmt.Stringer](input []T) []string {
 	return output
 }
 
+// Updates a chat can queue while it is busy, e.g. with a scan
+const chatUpdateBuffer = 32
+
+// Processes the updates of the chat until the channel is closed.
+func (chat *telegramChat) run() {
+	for update := range chat.updates {
+		if update.Message != nil {
+			fmt.Printf("Update is message\n")
+			chat.handleMessage(update.Message)
+		} else if update.CallbackQuery != nil {
+			fmt.Printf("Update is callback\n")
+			chat.handleCallbackQuery(update.CallbackQuery)
+		}
+	}
+}
+
 func (chat *telegramChat) handleMessage(message *tgbotapi.Message) {
 	fmt.Println("Message received: " + message.Text)
 	fmt.Println("Current state: " + chat.state.String())
-	if message.Text == "/restart" || chat.state == stateInit {
+	if message.Document != nil {
+		chat.receiveFile(message.Document.FileID, message.Document.FileName, message.Document.MimeType)
+		return
+	}
+	if len(message.Photo) > 0 {
+		// Photos are sent in several sizes, the last one is the largest
+		photo := message.Photo[len(message.Photo)-1]
+		chat.receiveFile(photo.FileID, fmt.Sprintf("photo_%d.jpg", message.MessageID), "image/jpeg")
+		return
+	}
+	if chat.handleCommand(message.Text) {
+		return
+	}
+	switch {
+	case message.Text == "/restart" || chat.state == stateInit:
 		chat.runInit()
+	case chat.state == statePrintCopies:
+		copies, err := parsePrintCopies(message.Text)
+		if err != nil {
+			chat.sendText(fmt.Sprintf("Cannot print %s copies: %s", strings.TrimSpace(message.Text), err.Error()))
+			return
+		}
+		chat.currentPrintOptions.copies = copies
+		chat.prepStatePrintPageRanges()
+	case chat.state == statePrintPageRanges:
+		pageRanges := PrintPageRanges(strings.TrimSpace(message.Text))
+		if _, err := pageRanges.ranges(); err != nil {
+			chat.sendText(fmt.Sprintf("Cannot use page range %s: %s", pageRanges, err.Error()))
+			return
+		}
+		chat.currentPrintOptions.pageRanges = pageRanges
+		chat.prepStatePrintSides()
+	}
+}
+
+// Handles commands that are independent of the chat state. Returns false if the text is no such command.
+func (chat *telegramChat) handleCommand(text string) bool {
+	command, argument, _ := strings.Cut(strings.TrimSpace(text), " ")
+	// Commands in group chats are suffixed with the bot name, e.g. /jobs@scanner_bot
+	command, _, _ = strings.Cut(command, "@")
+	switch command {
+	case "/printers":
+		chat.sendPrinterStatus()
+	case "/jobs":
+		chat.sendPrintJobs()
+	case "/canceljob":
+		chat.cancelPrintJob(argument, 0)
+	case "/blankpages":
+		chat.setBlankPageRemoval(argument)
+	default:
+		return false
 	}
+	return true
+}
+
+// Switches blank page removal for feeder scans of this chat on, off or back to the function default.
+func (chat *telegramChat) setBlankPageRemoval(argument string) {
+	removal := BlankPageRemoval(strings.ToLower(strings.TrimSpace(argument)))
+	if _, ok := blankPageRemoval[removal]; !ok {
+		chat.sendText(fmt.Sprintf("Blank page removal is %s, use /blankpages on, off or default", chat.blankPageRemoval))
+		return
+	}
+	chat.blankPageRemoval = removal
+	chat.sendText(fmt.Sprintf("Blank page removal set to %s", removal))
+}
+
+func (chat *telegramChat) removesBlankPages() bool {
+	if chat.currentFunction.source != adf || !chat.currentFunction.getFormat().isPdf() {
+		return false
+	}
+	switch chat.blankPageRemoval {
+	case blankPagesOn:
+		return true
+	case blankPagesOff:
+		return false
+	}
+	return chat.currentFunction.removeBlankPages
+}
+
+// Drops blank pages from a feeder scan and reports how many were removed.
+func (chat *telegramChat) removeBlankPages(file io.ReadCloser) (io.ReadCloser, error) {
+	defer file.Close()
+	spans, err := getPages(readerToReadSeeker(file))
+	if err != nil {
+		return nil, err
+	}
+	pages, removed, err := removeBlankPages(pagesToReadSeekers(spans), chat.scanner.blankPageThreshold)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("all %d pages are blank", removed)
+	}
+	if removed > 0 {
+		chat.sendText(fmt.Sprintf("Removed %d blank pages", removed))
+	}
+	return mergePages(pages), nil
+}
+
+// Adds a text layer to the scan. Falls back to the scan as it is if ocr is not available or fails.
+func (chat *telegramChat) recognizeText(file io.ReadCloser) io.ReadCloser {
+	if chat.ocr == nil || !chat.ocr.available() {
+		chat.sendText("OCR is not available, sending the scan without searchable text")
+		return file
+	}
+	defer file.Close()
+	scan := readerToReadSeeker(file)
+	recognized, err := chat.ocr.recognize(context.Background(), scan)
+	if err != nil {
+		fmt.Printf("failed to recognize text: %s\n", err.Error())
+		chat.sendText("OCR failed, sending the scan without searchable text: " + err.Error())
+		scan.Seek(0, io.SeekStart)
+		return io.NopCloser(scan)
+	}
+	return io.NopCloser(recognized)
+}
+
+func (chat *telegramChat) sendPrinterStatus() {
+	if chat.printer == nil {
+		chat.sendText("No printer configured")
+		return
+	}
+	status, err := chat.printer.getStatus()
+	if err != nil {
+		fmt.Printf("failed to get printer status: %s\n", err.Error())
+		chat.sendText("Failed to get printer status: " + err.Error())
+		return
+	}
+	chat.sendText(status.String())
+}
+
+// Creates the text and cancel buttons listing the active print jobs.
+func (chat *telegramChat) printJobsMessage() (string, tgbotapi.InlineKeyboardMarkup, error) {
+	keyboard := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	jobs, err := chat.printer.getJobs()
+	if err != nil {
+		return "", keyboard, err
+	}
+	if len(jobs) == 0 {
+		return "No active print jobs", keyboard, nil
+	}
+	var builder strings.Builder
+	for _, job := range jobs {
+		builder.WriteString(job.String() + "\n")
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Cancel job %d", job.id), fmt.Sprintf("/canceljob %d", job.id)),
+		))
+	}
+	return builder.String(), keyboard, nil
+}
+
+func (chat *telegramChat) sendPrintJobs() {
+	if chat.printer == nil {
+		chat.sendText("No printer configured")
+		return
+	}
+	text, keyboard, err := chat.printJobsMessage()
+	if err != nil {
+		fmt.Printf("failed to get print jobs: %s\n", err.Error())
+		chat.sendText("Failed to get print jobs: " + err.Error())
+		return
+	}
+	message := tgbotapi.NewMessage(chat.id, text)
+	if len(keyboard.InlineKeyboard) > 0 {
+		message.ReplyMarkup = keyboard
+	}
+	if _, err := chat.bot.bot.Send(message); err != nil {
+		fmt.Printf("Failed to send message: %s\n", err.Error())
+	}
+}
+
+// Cancels a print job. If jobsMessageId is set, the job list in that message is refreshed.
+func (chat *telegramChat) cancelPrintJob(argument string, jobsMessageId int) {
+	if chat.printer == nil {
+		chat.sendText("No printer configured")
+		return
+	}
+	jobId, err := strconv.Atoi(strings.TrimSpace(argument))
+	if err != nil {
+		chat.sendText("Usage: /canceljob <job id>")
+		return
+	}
+	if err := chat.printer.cancelJob(jobId); err != nil {
+		fmt.Printf("failed to cancel job: %s\n", err.Error())
+		chat.sendText(fmt.Sprintf("Failed to cancel job %d: %s", jobId, err.Error()))
+		return
+	}
+	if jobsMessageId == 0 {
+		chat.sendText(fmt.Sprintf("Canceled job %d", jobId))
+		return
+	}
+	text, keyboard, err := chat.printJobsMessage()
+	if err != nil {
+		fmt.Printf("failed to get print jobs: %s\n", err.Error())
+		return
+	}
+	if _, err := chat.bot.bot.Send(tgbotapi.NewEditMessageTextAndMarkup(chat.id, jobsMessageId, text, keyboard)); err != nil {
+		fmt.Printf("Failed to send message: %s\n", err.Error())
+	}
+}
+
+func (chat *telegramChat) receiveFile(fileId string, fileName string, mimeType string) {
+	if chat.printer == nil {
+		chat.sendText("No printer configured")
+		return
+	}
+	file, err := chat.downloadFile(fileId)
+	if err != nil {
+		fmt.Printf("failed to download file: %s\n", err.Error())
+		chat.sendText("Failed to download " + fileName)
+		return
+	}
+	defer file.Close()
+	pdf, pdfName, err := chat.converter.toPdf(file, fileName, mimeType)
+	if err != nil {
+		fmt.Printf("failed to convert file: %s\n", err.Error())
+		chat.sendText(err.Error())
+		return
+	}
+	chat.printFile = pdf
+	chat.printFileName = pdfName
+	chat.deleteLastMessage()
+	if chat.currentPrintOptions.isSet() {
+		chat.prepStatePrintUseLast()
+	} else {
+		chat.prepStatePrintCopies()
+	}
+}
+
+func (chat *telegramChat) printDocument() {
+	chat.deleteLastMessage()
+	chat.state = stateInit
+	if chat.printFile == nil {
+		chat.sendText("No document to print, please send it again")
+		return
+	}
+	jobId, err := chat.printer.print(chat.printFile, chat.printFileName, chat.currentPrintOptions)
+	chat.printFile = nil
+	if err != nil {
+		fmt.Printf("failed to print: %s\n", err.Error())
+		chat.sendText(fmt.Sprintf("Failed to print %s: %s", chat.printFileName, err.Error()))
+		return
+	}
+	chat.sendText(fmt.Sprintf("Printing %s (job %d)", chat.printFileName, jobId))
 }
 
 func (chat *telegramChat) handleCallbackQuery(callbackQuery *tgbotapi.CallbackQuery) {
 	fmt.Println("Message received: " + callbackQuery.Data)
 	fmt.Println("Current state: " + chat.state.String())
+	if jobId, ok := strings.CutPrefix(callbackQuery.Data, "/canceljob "); ok {
+		chat.cancelPrintJob(jobId, callbackQuery.Message.MessageID)
+		return
+	}
 	switch chat.state {
 	case stateInit:
 		chat.runInit()
 
 	case stateUseLast:
+		if AdvancedAction(callbackQuery.Data) == openAdvanced {
+			chat.prepStateAdvanced()
+			break
+		}
 		if Decision(callbackQuery.Data) == yes {
 			chat.chooseScanState()
-		} else {
-			chat.prepStateTarget()
+			break
+		}
+		chat.prepStateScanner()
+
+	case stateScanner:
+		scanner := chat.getScanner(callbackQuery.Data)
+		if scanner == nil {
+			fmt.Printf("Unknown scanner %s\n", callbackQuery.Data)
+			break
+		}
+		chat.scanner = scanner
+		chat.prepStateTarget()
+
+	case stateAdvanced:
+		switch AdvancedAction(callbackQuery.Data) {
+		case brightnessDown:
+			chat.currentAdjustments.brightness = clampAdjustment(chat.currentAdjustments.brightness - adjustmentStep)
+		case brightnessUp:
+			chat.currentAdjustments.brightness = clampAdjustment(chat.currentAdjustments.brightness + adjustmentStep)
+		case contrastDown:
+			chat.currentAdjustments.contrast = clampAdjustment(chat.currentAdjustments.contrast - adjustmentStep)
+		case contrastUp:
+			chat.currentAdjustments.contrast = clampAdjustment(chat.currentAdjustments.contrast + adjustmentStep)
+		case toggleLineart:
+			chat.currentAdjustments.lineart = !chat.currentAdjustments.lineart
+		case resetAdvanced:
+			chat.currentAdjustments = ScannerAdjustments{}
+		case closeAdvanced:
+			chat.prepStateUseLast()
+			return
 		}
+		chat.prepStateAdvanced()
 
 	case stateTarget:
-		chat.currentTarget = ScannerTarget(callbackQuery.Data)
+		chat.currentTarget = parseScannerTarget(callbackQuery.Data)
 		chat.prepStateSource()
 
 	case stateSource:
@@ -148,14 +651,46 @@ func (chat *telegramChat) handleCallbackQuery(callbackQuery *tgbotapi.CallbackQu
 
 	case stateMode:
 		chat.currentMode = ScannerMode(callbackQuery.Data)
+		formats := chat.scanner.getFormats(chat.currentTarget, chat.currentSource, chat.currentMode)
+		if len(formats) > 1 {
+			chat.prepStateFormat()
+			break
+		}
+		chat.currentFormat = formatPdf
+		if len(formats) == 1 {
+			chat.currentFormat = formats[0]
+		}
+		chat.selectResolution()
+
+	case stateFormat:
+		chat.currentFormat = ScannerFormat(callbackQuery.Data)
+		chat.selectResolution()
+
+	case stateResolution:
+		resolution, err := parseScannerResolution(callbackQuery.Data)
+		if err != nil {
+			fmt.Printf("Invalid resolution %s\n", callbackQuery.Data)
+			break
+		}
+		chat.currentResolution = resolution
+		chat.prepStateArea()
+
+	case stateArea:
+		area := chat.scanner.getArea(callbackQuery.Data)
+		if area == nil {
+			fmt.Printf("Unknown scan area %s\n", callbackQuery.Data)
+			break
+		}
+		chat.currentArea = *area
 		chat.chooseScanState()
 
 	case stateScanDuplexFront:
 		if Decision(callbackQuery.Data) == yes {
-			file, _, err := chat.currentFunction.scan(chat.scanner.endpoint, chat.scanner.deviceId)
+			file, _, err := chat.scan()
 			if err != nil {
-				fmt.Printf("failed to scan: %s\n", err.Error())
-				chat.prepStateUseLast()
+				if !chat.scanFailed("Front scan failed: ", err) {
+					chat.prepStateUseLast()
+				}
 			} else if chat.currentDuplex == yes {
 				chat.duplexFrontFile = readerToReadSeeker(file)
 				chat.prepStateScanDuplexRear()
@@ -166,55 +701,332 @@ func (chat *telegramChat) handleCallbackQuery(callbackQuery *tgbotapi.CallbackQu
 			chat.prepStateUseLast()
 		}
 	case stateScanDuplexRear:
-		if Decision(callbackQuery.Data) == yes {
-			file, filename, err := chat.currentFunction.scan(chat.scanner.endpoint, chat.scanner.deviceId)
-			if err != nil {
-				fmt.Printf("failed to scan: %s\n", err.Error())
-				chat.prepStateUseLast()
-				break
-			}
-			front := chat.duplexFrontFile
-			rear := readerToReadSeeker(file)
-			frontPages, err := getPages(front)
-			if err != nil {
-				chat.prepStateUseLast()
-				break
-			}
-			rearPages, err := getPages(rear)
-			if err != nil {
-				chat.prepStateUseLast()
-				break
-			}
-			err = chat.finish(chat.currentTarget, orderAndMerge(frontPages, rearPages), filename)
-			chat.prepStateUseLast()
-			if err != nil {
-				fmt.Println(err)
-			}
+		if Decision(callbackQuery.Data) == yes || Decision(callbackQuery.Data) == yesRotate {
+			chat.rotateRear = Decision(callbackQuery.Data) == yesRotate
+			chat.scanDuplexRear()
 		} else {
 			chat.prepStateUseLast()
 		}
+	case stateDuplexMismatch:
+		switch DuplexMismatchAction(callbackQuery.Data) {
+		case retryRear:
+			chat.prepStateScanDuplexRear()
+		case appendUnpaired, keepFronts:
+			// The action combines passes of any length, ordering cannot fail
+			pages, _ := orderPages(chat.duplexFrontPages, chat.duplexRearPages, DuplexMismatchAction(callbackQuery.Data))
+			chat.finishDuplex(pages)
+		default:
+			fmt.Printf("Unknown action %s\n", callbackQuery.Data)
+		}
 	case stateScanSimple:
+		if PreviewAction(callbackQuery.Data) == requestPreview {
+			chat.sendPreview()
+			break
+		}
 		if Decision(callbackQuery.Data) == yes {
-			file, filename, err := chat.currentFunction.scan(chat.scanner.endpoint, chat.scanner.deviceId)
-			if err != nil {
-				fmt.Printf("failed to scan: %s\n", err.Error())
-			} else {
-				err = chat.finish(chat.currentTarget, file, filename)
-				chat.prepStateUseLast()
-				if err != nil {
-					fmt.Println(err)
-				}
-			}
+			chat.startScan()
+			break
 		}
 		chat.prepStateUseLast()
 
+	case statePreview:
+		// The preview is a photo, its caption cannot be edited into the next step
+		chat.deleteLastMessage()
+		switch PreviewAction(callbackQuery.Data) {
+		case previewScan:
+			chat.startScan()
+		case previewAdjust:
+			chat.prepStateArea()
+		default:
+			chat.prepStateUseLast()
+		}
+
+	case stateBatch:
+		switch BatchAction(callbackQuery.Data) {
+		case scanNextPage:
+			chat.scanBatchPage()
+		case discardLastPage:
+			if len(chat.batchPages) > 0 {
+				chat.batchPages = chat.batchPages[:len(chat.batchPages)-1]
+			}
+			chat.prepStateBatch()
+		case finishBatch:
+			chat.finishBatch()
+		default:
+			fmt.Printf("Unknown action %s\n", callbackQuery.Data)
+		}
+
+	case statePrintUseLast:
+		if Decision(callbackQuery.Data) == yes {
+			chat.printDocument()
+		} else {
+			chat.prepStatePrintCopies()
+		}
+
+	case statePrintCopies:
+		copies, err := strconv.Atoi(callbackQuery.Data)
+		if err != nil {
+			fmt.Printf("Invalid number of copies %s\n", callbackQuery.Data)
+			break
+		}
+		chat.currentPrintOptions.copies = PrintCopies(copies)
+		chat.prepStatePrintPageRanges()
+
+	case statePrintPageRanges:
+		chat.currentPrintOptions.pageRanges = PrintPageRanges(callbackQuery.Data)
+		chat.prepStatePrintSides()
+
+	case statePrintSides:
+		chat.currentPrintOptions.sides = PrintSides(callbackQuery.Data)
+		chat.prepStatePrintColor()
+
+	case statePrintColor:
+		chat.currentPrintOptions.colorMode = PrintColorMode(callbackQuery.Data)
+		chat.prepStatePrintMedia()
+
+	case statePrintMedia:
+		chat.currentPrintOptions.media = PrintMedia(callbackQuery.Data)
+		chat.printDocument()
+
 	default:
 		fmt.Printf("Chat state %s is unknown", chat.state)
 	}
 
 }
 
+// Scans with the current function. Other chats may be using the scanner, the current message shows the queue position then.
+func (chat *telegramChat) scan() (io.ReadCloser, string, error) {
+	ctx, status, done := chat.startScanJob()
+	defer done()
+	return chat.scanner.scan(ctx, chat.currentFunction, status.setPosition, status.progress)
+}
+
+// Shows the scan status with a cancel button in the current message and makes the job cancelable.
+// Returns the context of the job, the status it reports its progress to and a function ending the job.
+func (chat *telegramChat) startScanJob() (context.Context, *scanStatus, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	chat.scanMutex.Lock()
+	chat.scanCancel = cancel
+	chat.scanMutex.Unlock()
+
+	status := chat.showStatus()
+	status.setCancelable(true)
+	done := func() {
+		chat.scanMutex.Lock()
+		chat.scanCancel = nil
+		chat.scanMutex.Unlock()
+		cancel()
+		status.setCancelable(false)
+	}
+	return ctx, status, done
+}
+
+// Shows the status of the current job in the current message, which is sent again if it was deleted.
+// The status stays until the chat moves on to the next state.
+func (chat *telegramChat) showStatus() *scanStatus {
+	if chat.status != nil {
+		return chat.status
+	}
+	if chat.currentMessage.MessageID == 0 {
+		// The last message was deleted, e.g. the preview photo
+		var err error
+		chat.currentMessage, err = chat.bot.bot.Send(tgbotapi.NewMessage(chat.id, chat.scanner.String()))
+		if err != nil {
+			fmt.Printf("Failed to send message: %s\n", err.Error())
+		}
+	}
+	chat.status = newScanStatus(chat.bot.bot, chat.id, chat.currentMessage.MessageID, chat.scanner.String())
+	chat.status.update(func() {})
+	return chat.status
+}
+
+// Shows the stage of the current job if its status is shown.
+func (chat *telegramChat) setStage(stage ScanStage) {
+	if chat.status != nil {
+		chat.status.setStage(stage)
+	}
+}
+
+// Stops updating the status, the current message is used for something else.
+func (chat *telegramChat) closeStatus() {
+	if chat.status != nil {
+		chat.status.close()
+		chat.status = nil
+	}
+}
+
+// Cancels the running scan of the chat. It is called from the update loop,
+// as the goroutine of the chat is blocked by the scan. Returns false if no scan is running.
+func (chat *telegramChat) cancelRunningScan() bool {
+	chat.scanMutex.Lock()
+	defer chat.scanMutex.Unlock()
+	if chat.scanCancel == nil {
+		return false
+	}
+	chat.scanCancel()
+	return true
+}
+
+// Reports a failed scan. Canceled scans discard the pages of the job and return to the last configuration,
+// a canceled batch page only discards that page. Returns true if the scan was canceled.
+func (chat *telegramChat) scanFailed(message string, err error) bool {
+	if errors.Is(err, context.Canceled) && chat.state == stateBatch {
+		chat.sendText("Scan of the page canceled")
+		chat.prepStateBatch()
+		return true
+	}
+	if errors.Is(err, context.Canceled) {
+		chat.sendText("Scan canceled")
+		chat.duplexFrontFile = nil
+		chat.duplexFrontPages = nil
+		chat.duplexRearPages = nil
+		chat.batchPages = nil
+		chat.prepStateUseLast()
+		return true
+	}
+	fmt.Printf("failed to scan: %s\n", err.Error())
+	chat.sendText(message + err.Error())
+	return false
+}
+
+// Starts a single pass scan. Flatbed pdf scans collect pages until the user finishes the batch.
+func (chat *telegramChat) startScan() {
+	if chat.currentSource == flatbed && chat.currentFunction.getFormat().isPdf() {
+		chat.batchPages = nil
+		chat.batchFileName = ""
+		chat.scanBatchPage()
+		return
+	}
+	file, filename, err := chat.scan()
+	if err != nil {
+		if chat.scanFailed("Scan failed: ", err) {
+			return
+		}
+	} else if err = chat.finish(chat.currentTarget, file, filename); err != nil {
+		fmt.Println(err)
+		chat.sendText("Failed to deliver the scan: " + err.Error())
+	}
+	chat.prepStateUseLast()
+}
+
+func (chat *telegramChat) scanDuplexRear() {
+	file, filename, err := chat.scan()
+	if err != nil {
+		if !chat.scanFailed("Rear scan failed: ", err) {
+			chat.prepStateUseLast()
+		}
+		return
+	}
+	frontPages, err := getPages(chat.duplexFrontFile)
+	if err != nil {
+		chat.sendText("Could not read the front pages: " + err.Error())
+		chat.prepStateUseLast()
+		return
+	}
+	rearPages, err := getPages(readerToReadSeeker(file))
+	if err != nil {
+		chat.sendText("Could not read the rear pages: " + err.Error())
+		chat.prepStateUseLast()
+		return
+	}
+	chat.duplexFrontPages = pagesToReadSeekers(frontPages)
+	chat.duplexRearPages = pagesToReadSeekers(rearPages)
+	chat.duplexFileName = filename
+	chat.setStage(stageMergingDuplex)
+	if chat.rotateRear {
+		chat.duplexRearPages, err = rotatePages(chat.duplexRearPages)
+		if err != nil {
+			chat.sendText("Could not rotate the rear pages: " + err.Error())
+			chat.prepStateUseLast()
+			return
+		}
+	}
+	pages, err := orderPages(chat.duplexFrontPages, chat.duplexRearPages, "")
+	if err != nil {
+		fmt.Println(err)
+		chat.prepStateDuplexMismatch()
+		return
+	}
+	chat.finishDuplex(pages)
+}
+
+// Merges the pages of a manual duplex scan and delivers them to the target.
+func (chat *telegramChat) finishDuplex(pages []io.ReadSeeker) {
+	chat.showStatus()
+	chat.setStage(stageMergingDuplex)
+	err := chat.finish(chat.currentTarget, mergePages(pages), chat.duplexFileName)
+	if err != nil {
+		fmt.Println(err)
+		chat.sendText("Failed to deliver the scan: " + err.Error())
+	}
+	chat.duplexFrontFile = nil
+	chat.duplexFrontPages = nil
+	chat.duplexRearPages = nil
+	chat.prepStateUseLast()
+}
+
+func (chat *telegramChat) sendPreview() {
+	ctx, status, done := chat.startScanJob()
+	image, err := chat.scanner.preview(ctx, chat.currentFunction, status.setPosition)
+	done()
+	if err != nil {
+		if !chat.scanFailed("Preview failed: ", err) {
+			chat.prepStateUseLast()
+		}
+		return
+	}
+	chat.deleteLastMessage()
+	photo := tgbotapi.NewPhoto(chat.id, tgbotapi.FileBytes{Name: "preview.jpg", Bytes: image})
+	photo.Caption = "Preview of the scan area"
+	photo.ReplyMarkup = stringSliceToKeyboard(sliceToStringSlice([]PreviewAction{previewScan, previewAdjust, previewCancel}))
+	chat.currentMessage, err = chat.bot.bot.Send(photo)
+	if err != nil {
+		fmt.Printf("Failed to send preview: %s\n", err.Error())
+		chat.prepStateUseLast()
+		return
+	}
+	chat.state = statePreview
+}
+
+func (chat *telegramChat) scanBatchPage() {
+	file, filename, err := chat.scan()
+	if err != nil {
+		if chat.scanFailed("Scan failed: ", err) {
+			return
+		}
+	} else {
+		chat.batchPages = append(chat.batchPages, readerToReadSeeker(file))
+		file.Close()
+		if chat.batchFileName == "" {
+			chat.batchFileName = filename
+		}
+	}
+	chat.prepStateBatch()
+}
+
+func (chat *telegramChat) finishBatch() {
+	pages := chat.batchPages
+	chat.batchPages = nil
+	if len(pages) == 0 {
+		chat.sendText("No pages scanned")
+		chat.prepStateUseLast()
+		return
+	}
+	chat.showStatus()
+	var file io.ReadCloser = io.NopCloser(pages[0])
+	if len(pages) > 1 {
+		file = mergePages(pages)
+	}
+	err := chat.finish(chat.currentTarget, file, chat.batchFileName)
+	if err != nil {
+		fmt.Println(err)
+		chat.sendText("Failed to deliver the scan: " + err.Error())
+	}
+	chat.prepStateUseLast()
+}
+
 func (chat *telegramChat) deleteLastMessage() {
+	chat.closeStatus()
 	if chat.currentMessage.MessageID != 0 {
 		deleteMessage := tgbotapi.NewDeleteMessage(chat.id, chat.currentMessage.MessageID)
 		chat.bot.bot.Send(deleteMessage)
@@ -224,10 +1036,10 @@ func (chat *telegramChat) deleteLastMessage() {
 
 func (chat *telegramChat) runInit() {
 	chat.deleteLastMessage()
-	if chat.currentTarget != "" && chat.currentSource != "" && chat.currentMode != "" {
+	if chat.currentTarget != "" && chat.currentSource != "" && chat.currentMode != "" && chat.currentFormat != "" && chat.scanResolution() != 0 && chat.currentArea.name != "" {
 		chat.prepStateUseLast()
 	} else {
-		chat.prepStateTarget()
+		chat.prepStateScanner()
 	}
 }
 
@@ -235,10 +1047,25 @@ func (chat *telegramChat) finish(target ScannerTarget, file io.ReadCloser, fileN
 	if file == nil {
 		return fmt.Errorf("could not finish, file was nil")
 	}
+	if chat.removesBlankPages() {
+		chat.setStage(stageRemovingBlankPages)
+		var err error
+		file, err = chat.removeBlankPages(file)
+		if err != nil {
+			return err
+		}
+	}
 	switch target {
 	case telegram:
+		// Scans of the ocr pipeline already contain text
+		if chat.currentFunction.ocr && chat.currentFunction.getFormat().isPdf() && chat.currentFunction.getFormat() != formatOcrPdf {
+			chat.setStage(stageRecognizingText)
+			file = chat.recognizeText(file)
+		}
+		chat.setStage(stageSending)
 		return chat.sendFile(file, fileName)
 	case paperless:
+		chat.setStage(stageUploading)
 
 		url := chat.paperlessEndpoint + "/api/documents/post_document/"
 		method := "POST"
@@ -288,29 +1115,82 @@ func (chat *telegramChat) finish(target ScannerTarget, file io.ReadCloser, fileN
 		}
 		fmt.Println(string(body))
 		return nil
+	case printerTarget:
+		defer file.Close()
+		if chat.printer == nil {
+			return fmt.Errorf("no printer configured")
+		}
+		if !chat.currentFunction.getFormat().isPdf() {
+			return fmt.Errorf("only pdf scans can be printed")
+		}
+		chat.setStage(stagePrinting)
+		jobId, err := chat.printCopy(file, fileName)
+		if err != nil {
+			return err
+		}
+		chat.sendText(fmt.Sprintf("Printing copy (job %d)", jobId))
+		return nil
 	}
 	return fmt.Errorf("target not supported")
 }
 
-func orderAndMerge(front []*api.PageSpan, rear []*api.PageSpan) io.ReadCloser {
-	if front == nil || rear == nil {
-		fmt.Println("Front or rear pages are nil")
-		return nil
+// Prints a scanned copy. The options of earlier prints of the chat are not used, a copy is printed once
+// and completely, two-sided if the pages were scanned duplex.
+func (chat *telegramChat) printCopy(file io.Reader, fileName string) (int, error) {
+	return chat.printer.print(file, fileName, newCopyPrintOptions(chat.currentSource == adf && chat.currentDuplex == yes))
+}
+
+// A page of a manual duplex scan, index is its position in the front or rear pass.
+type duplexPage struct {
+	rear  bool
+	index int
+}
+
+// Returns the page order of a manual duplex scan. The rear pass was scanned back to front, so it is reversed
+// and interleaved with the front pass. Passes of different length need a mismatch action: appendUnpaired
+// puts the rear pages after the fronts, keepFronts drops them.
+func duplexPageOrder(fronts int, rears int, action DuplexMismatchAction) ([]duplexPage, error) {
+	order := []duplexPage{}
+	switch {
+	case action == keepFronts:
+		for i := 0; i < fronts; i++ {
+			order = append(order, duplexPage{index: i})
+		}
+	case action == appendUnpaired:
+		for i := 0; i < fronts; i++ {
+			order = append(order, duplexPage{index: i})
+		}
+		for i := rears - 1; i >= 0; i-- {
+			order = append(order, duplexPage{rear: true, index: i})
+		}
+	case fronts != rears:
+		return nil, fmt.Errorf("different number of front (%d) and rear pages (%d)", fronts, rears)
+	default:
+		for i := 0; i < fronts; i++ {
+			order = append(order, duplexPage{index: i}, duplexPage{rear: true, index: rears - i - 1})
+		}
 	}
-	if len(front) != len(rear) {
-		fmt.Printf("Different number of front (%d) and rear pages(%d)\n", len(front), len(rear))
-		return nil
+	return order, nil
+}
+
+// Arranges front and rear pages, see duplexPageOrder.
+func orderPages(front []io.ReadSeeker, rear []io.ReadSeeker, action DuplexMismatchAction) ([]io.ReadSeeker, error) {
+	order, err := duplexPageOrder(len(front), len(rear), action)
+	if err != nil {
+		return nil, err
 	}
 	pages := []io.ReadSeeker{}
-	for i := 0; i < len(front); i++ {
-		frontPage := front[i]
-		rearPage := rear[len(rear)-i-1]
-		if frontPage == nil || rearPage == nil {
-			fmt.Printf("Nil page found at index %d\n", i)
-			return nil
+	for _, page := range order {
+		if page.rear {
+			pages = append(pages, rear[page.index])
+		} else {
+			pages = append(pages, front[page.index])
 		}
-		pages = append(pages, readerToReadSeeker(frontPage.Reader), readerToReadSeeker(rearPage.Reader))
 	}
+	return pages, nil
+}
+
+func mergePages(pages []io.ReadSeeker) io.ReadCloser {
 	reader, writer := io.Pipe()
 	go func() {
 		defer writer.Close()
@@ -323,6 +1203,30 @@ func orderAndMerge(front []*api.PageSpan, rear []*api.PageSpan) io.ReadCloser {
 	return reader
 }
 
+func pagesToReadSeekers(spans []*api.PageSpan) []io.ReadSeeker {
+	pages := []io.ReadSeeker{}
+	for _, span := range spans {
+		if span != nil {
+			pages = append(pages, readerToReadSeeker(span.Reader))
+		}
+	}
+	return pages
+}
+
+// Rotates every page by 180°, for scanners that flip the stack between the passes.
+func rotatePages(pages []io.ReadSeeker) ([]io.ReadSeeker, error) {
+	rotated := []io.ReadSeeker{}
+	for _, page := range pages {
+		var buffer bytes.Buffer
+		if err := api.Rotate(page, &buffer, 180, nil, model.NewDefaultConfiguration()); err != nil {
+			fmt.Printf("failed to rotate page: %s\n", err.Error())
+			return nil, err
+		}
+		rotated = append(rotated, bytes.NewReader(buffer.Bytes()))
+	}
+	return rotated, nil
+}
+
 func readerToReadSeeker(file io.Reader) io.ReadSeeker {
 	fileBytes, err := io.ReadAll(file)
 	if err != nil {
@@ -345,8 +1249,29 @@ func (chat *telegramChat) updateMessage(text string, replyMarkup tgbotapi.Inline
 }
 
 func (chat *telegramChat) chooseScanState() {
-	if chat.currentSource == adf && chat.currentDuplex == yes {
+	function := chat.scanner.getFunction(chat.currentTarget, chat.currentSource, chat.currentMode, chat.currentFormat)
+	if function == nil {
+		chat.deleteLastMessage()
+		chat.sendText(fmt.Sprintf("%s does not support %s %s scans as %s to %s", chat.scanner, chat.currentSource, chat.currentMode, chat.currentFormat, chat.currentTarget))
+		chat.prepStateScanner()
+		return
+	}
+	chat.currentFunction = *function
+	if chat.currentFunction.resolution == 0 {
+		chat.currentFunction.resolution = chat.currentResolution
+	}
+	chat.currentFunction.area = chat.currentArea
+	chat.currentFunction.adjustments = chat.currentAdjustments
+	if chat.currentSource == adf && chat.currentDuplex == yes && chat.scanner.supportsDuplex() {
+		// The feeder scans both sides, no need for the manual front and rear passes
+		chat.currentFunction.duplex = true
+		chat.prepStateScanSimple()
+	} else if chat.currentSource == adf && chat.currentDuplex == yes && chat.currentFunction.getFormat().isPdf() {
 		chat.prepStateScanDuplexFront()
+	} else if chat.currentSource == adf && chat.currentDuplex == yes {
+		// Front and rear pass can only be interleaved in pdfs
+		chat.sendText(fmt.Sprintf("Duplex scans are not available as %s, scanning the front pages only", chat.currentFormat))
+		chat.prepStateScanSimple()
 	} else {
 		chat.prepStateScanSimple()
 	}
@@ -355,12 +1280,47 @@ func (chat *telegramChat) chooseScanState() {
 func (chat *telegramChat) prepStateUseLast() {
 	chat.deleteLastMessage()
 	var builder strings.Builder
-	builder.WriteString(fmt.Sprintf("Use last configuration:\nTarget: %s\nSource: %s\nMode: %s\n", chat.currentTarget, chat.currentSource, chat.currentMode))
+	builder.WriteString("Use last configuration:\n")
+	if len(chat.scanners) > 1 {
+		builder.WriteString(fmt.Sprintf("Scanner: %s\n", chat.scanner))
+	}
+	builder.WriteString(fmt.Sprintf("Target: %s\nSource: %s\nMode: %s\nFormat: %s\nResolution: %s\nArea: %s\n", chat.currentTarget, chat.currentSource, chat.currentMode, chat.currentFormat, chat.scanResolution(), chat.currentArea))
 	if chat.currentSource == adf {
-		builder.WriteString(fmt.Sprintf("Duplex: %s", chat.currentDuplex))
+		builder.WriteString(fmt.Sprintf("Duplex: %s\n", chat.currentDuplex))
+	}
+	if chat.currentAdjustments.isSet() {
+		builder.WriteString(chat.currentAdjustments.String())
+	}
+	prepState(chat, stateUseLast, []fmt.Stringer{yes, no, openAdvanced}, builder.String(), true)
+}
+
+func (chat *telegramChat) prepStateAdvanced() {
+	keyboard := stringMatrixToKeyboard([][]string{
+		sliceToStringSlice([]AdvancedAction{brightnessDown, brightnessUp}),
+		sliceToStringSlice([]AdvancedAction{contrastDown, contrastUp}),
+		sliceToStringSlice([]AdvancedAction{toggleLineart, resetAdvanced}),
+		sliceToStringSlice([]AdvancedAction{closeAdvanced}),
+	})
+	prepStateKeyboard(chat, stateAdvanced, keyboard, "Advanced settings\n"+chat.currentAdjustments.String(), false)
+}
+func (chat *telegramChat) getScanner(name string) *scanner {
+	for _, scanner := range chat.scanners {
+		if scanner.name == name {
+			return scanner
+		}
+	}
+	return nil
+}
+
+// Lets the chat choose a scanner, skipped if only one is configured.
+func (chat *telegramChat) prepStateScanner() {
+	if len(chat.scanners) == 1 {
+		chat.prepStateTarget()
+		return
 	}
-	prepState(chat, stateUseLast, []fmt.Stringer{yes, no}, builder.String(), true)
+	prepState(chat, stateScanner, chat.scanners, "Select a scanner", chat.currentMessage.MessageID == 0)
 }
+
 func (chat *telegramChat) prepStateTarget() {
 	prepState(chat, stateTarget, chat.scanner.getTargets(), "Select a target to scan to", chat.currentMessage.MessageID == 0)
 }
@@ -372,27 +1332,109 @@ func (chat *telegramChat) prepStateMode() {
 	prepState(chat, stateMode, chat.scanner.getModes(chat.currentTarget, ScannerSource(chat.currentSource)), "Select a scan mode", false)
 }
 
+func (chat *telegramChat) prepStateFormat() {
+	prepState(chat, stateFormat, chat.scanner.getFormats(chat.currentTarget, chat.currentSource, chat.currentMode), "Select an output format", false)
+}
+
+// Continues with the resolution, which is only selected if the function does not configure one.
+func (chat *telegramChat) selectResolution() {
+	function := chat.scanner.getFunction(chat.currentTarget, chat.currentSource, chat.currentMode, chat.currentFormat)
+	if function != nil && function.resolution != 0 {
+		chat.prepStateArea()
+		return
+	}
+	chat.prepStateResolution()
+}
+
+// The resolution of the next scan, the one configured for the function or else the selected one.
+// The selection is kept, so it is used again for functions without a resolution.
+func (chat *telegramChat) scanResolution() ScannerResolution {
+	function := chat.scanner.getFunction(chat.currentTarget, chat.currentSource, chat.currentMode, chat.currentFormat)
+	if function != nil && function.resolution != 0 {
+		return function.resolution
+	}
+	return chat.currentResolution
+}
+
+func (chat *telegramChat) prepStateResolution() {
+	prepState(chat, stateResolution, chat.scanner.getResolutions(), "Select a resolution", false)
+}
+
+func (chat *telegramChat) prepStateArea() {
+	keyboard := [][]string{{}}
+	for _, area := range chat.scanner.areas {
+		// Three areas per row, the names do not fit in a single row
+		if len(keyboard[len(keyboard)-1]) == 3 {
+			keyboard = append(keyboard, []string{})
+		}
+		keyboard[len(keyboard)-1] = append(keyboard[len(keyboard)-1], area.name)
+	}
+	prepStateKeyboard(chat, stateArea, stringMatrixToKeyboard(keyboard), "Select a scan area", false)
+}
+
 func (chat *telegramChat) prepStateDuplex() {
 	prepState(chat, stateDuplex, []fmt.Stringer{yes, no}, "Duplex scan?", false)
 }
 
 func (chat *telegramChat) prepStateScanDuplexFront() {
 	prepState(chat, stateScanDuplexFront, []fmt.Stringer{yes, no}, "Start front scan?", false)
-	chat.currentFunction = *chat.scanner.getFunction(chat.currentTarget, chat.currentSource, chat.currentMode)
 }
 func (chat *telegramChat) prepStateScanDuplexRear() {
 	chat.deleteLastMessage()
-	prepState(chat, stateScanDuplexRear, []fmt.Stringer{yes, no}, "Start rear scan?", false)
-	chat.currentFunction = *chat.scanner.getFunction(chat.currentTarget, chat.currentSource, chat.currentMode)
+	prepState(chat, stateScanDuplexRear, []fmt.Stringer{yes, yesRotate, no}, "Start rear scan?", false)
+}
+
+func (chat *telegramChat) prepStateBatch() {
+	message := fmt.Sprintf("%d pages scanned. Place the next page on the scanner or finish the document.", len(chat.batchPages))
+	prepState(chat, stateBatch, []BatchAction{scanNextPage, finishBatch, discardLastPage}, message, false)
+}
+
+func (chat *telegramChat) prepStateDuplexMismatch() {
+	chat.deleteLastMessage()
+	message := fmt.Sprintf("The front pass has %d pages but the rear pass has %d, maybe pages were fed twice.\nHow do you want to continue?", len(chat.duplexFrontPages), len(chat.duplexRearPages))
+	prepState(chat, stateDuplexMismatch, []DuplexMismatchAction{retryRear, appendUnpaired, keepFronts}, message, true)
 }
 
 func (chat *telegramChat) prepStateScanSimple() {
-	prepState(chat, stateScanSimple, []fmt.Stringer{yes, no}, "Start scan?", false)
-	chat.currentFunction = *chat.scanner.getFunction(chat.currentTarget, chat.currentSource, chat.currentMode)
+	if chat.currentSource == flatbed && chat.scanner.supportsPreview() {
+		prepState(chat, stateScanSimple, []fmt.Stringer{yes, requestPreview, no}, "Start scan?", false)
+	} else {
+		prepState(chat, stateScanSimple, []fmt.Stringer{yes, no}, "Start scan?", false)
+	}
+}
+
+func (chat *telegramChat) prepStatePrintUseLast() {
+	message := fmt.Sprintf("Print %s with last print settings:\n%s", chat.printFileName, chat.currentPrintOptions.summary())
+	prepState(chat, statePrintUseLast, []fmt.Stringer{yes, no}, message, true)
+}
+
+func (chat *telegramChat) prepStatePrintCopies() {
+	copies := []PrintCopies{1, 2, 3, 4, 5}
+	prepState(chat, statePrintCopies, copies, fmt.Sprintf("How many copies of %s?\nSelect or send a number", chat.printFileName), chat.currentMessage.MessageID == 0)
+}
+
+func (chat *telegramChat) prepStatePrintPageRanges() {
+	prepState(chat, statePrintPageRanges, []PrintPageRanges{allPages}, "Which pages?\nSelect all or send a range like 1-3,5", false)
+}
+
+func (chat *telegramChat) prepStatePrintSides() {
+	prepState(chat, statePrintSides, []PrintSides{oneSided, twoSidedLongEdge, twoSidedShortEdge}, "Print one- or two-sided?", false)
+}
+
+func (chat *telegramChat) prepStatePrintColor() {
+	prepState(chat, statePrintColor, []PrintColorMode{printColor, printMonochrome}, "Select a color mode", false)
+}
+
+func (chat *telegramChat) prepStatePrintMedia() {
+	prepState(chat, statePrintMedia, []PrintMedia{mediaA4, mediaA5, mediaLetter, mediaLegal}, "Select a paper size", false)
 }
 
 func prepState[T fmt.Stringer](chat *telegramChat, state ChatState, slice []T, message string, init bool) {
-	keyboard := stringSliceToKeyboard(sliceToStringSlice(slice))
+	prepStateKeyboard(chat, state, stringSliceToKeyboard(sliceToStringSlice(slice)), message, init)
+}
+
+func prepStateKeyboard(chat *telegramChat, state ChatState, keyboard tgbotapi.InlineKeyboardMarkup, message string, init bool) {
+	chat.closeStatus()
 	if init || chat.currentMessage.MessageID == 0 {
 		var err error
 		answer := tgbotapi.NewMessage(chat.id, message)
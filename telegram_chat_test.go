@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+func TestPrintCopyIgnoresLastPrintOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    ScannerSource
+		duplex    Decision
+		wantSides string
+	}{
+		{name: "flatbed", source: flatbed, wantSides: ""},
+		{name: "adf", source: adf, duplex: no, wantSides: ""},
+		{name: "adf duplex", source: adf, duplex: yes, wantSides: "two-sided-long-edge"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var request *ippMessage
+			server := newIppServer(t, ippMessage{
+				groups: []ippGroup{
+					{tag: ippTagJob, attributes: []ippAttribute{newIppAttribute(ippTagInteger, "job-id", 3)}},
+				},
+			}, &request)
+			printer, err := newPrinter(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chat := &telegramChat{
+				printer:       printer,
+				currentSource: test.source,
+				currentDuplex: test.duplex,
+				// Left over from printing an earlier document
+				currentPrintOptions: PrintOptions{copies: 4, pageRanges: "3-5", sides: twoSidedShortEdge},
+			}
+			if _, err := chat.printCopy(strings.NewReader("%PDF-1.4"), "scan.pdf"); err != nil {
+				t.Fatal(err)
+			}
+			job := request.getGroups(ippTagJob)[0]
+			if copies := job.getInt("copies"); copies != 1 {
+				t.Errorf("copies = %d, want 1", copies)
+			}
+			if ranges := job.getAttribute("page-ranges"); ranges != nil {
+				t.Errorf("page-ranges = %v, want none", ranges)
+			}
+			if sides := job.getString("sides"); sides != test.wantSides {
+				t.Errorf("sides = %q, want %q", sides, test.wantSides)
+			}
+		})
+	}
+}
+
+func TestScanResolutionKeepsSelection(t *testing.T) {
+	scanner := &scanner{functions: []ScannerFunction{
+		{target: telegram, source: flatbed, mode: color, format: formatPdf},
+		{target: paperless, source: adf, mode: gray, format: formatPdf, resolution: 300},
+	}}
+	chat := &telegramChat{scanner: scanner, currentResolution: 150, currentMode: color, currentFormat: formatPdf}
+
+	chat.currentTarget, chat.currentSource, chat.currentMode = paperless, adf, gray
+	if resolution := chat.scanResolution(); resolution != 300 {
+		t.Errorf("pinned resolution = %d, want 300", resolution)
+	}
+	chat.currentTarget, chat.currentSource, chat.currentMode = telegram, flatbed, color
+	if resolution := chat.scanResolution(); resolution != 150 {
+		t.Errorf("selected resolution = %d, want 150", resolution)
+	}
+}
+
+func TestDuplexPageOrder(t *testing.T) {
+	front := func(index int) duplexPage { return duplexPage{index: index} }
+	rear := func(index int) duplexPage { return duplexPage{rear: true, index: index} }
+	tests := []struct {
+		name    string
+		fronts  int
+		rears   int
+		action  DuplexMismatchAction
+		want    []duplexPage
+		wantErr bool
+	}{
+		{name: "empty", want: []duplexPage{}},
+		{name: "single sheet", fronts: 1, rears: 1, want: []duplexPage{front(0), rear(0)}},
+		// The rear pass starts with the back of the last sheet
+		{name: "reversed backs", fronts: 3, rears: 3, want: []duplexPage{front(0), rear(2), front(1), rear(1), front(2), rear(0)}},
+		{name: "missing rear page", fronts: 3, rears: 2, wantErr: true},
+		{name: "extra rear page", fronts: 2, rears: 3, wantErr: true},
+		{name: "append unpaired", fronts: 2, rears: 3, action: appendUnpaired, want: []duplexPage{front(0), front(1), rear(2), rear(1), rear(0)}},
+		{name: "keep fronts", fronts: 3, rears: 2, action: keepFronts, want: []duplexPage{front(0), front(1), front(2)}},
+		{name: "keep fronts without rears", fronts: 2, action: keepFronts, want: []duplexPage{front(0), front(1)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			order, err := duplexPageOrder(test.fronts, test.rears, test.action)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", order)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(order, test.want) {
+				t.Errorf("order = %v, want %v", order, test.want)
+			}
+		})
+	}
+}
+
+func TestOrderPages(t *testing.T) {
+	front := []io.ReadSeeker{strings.NewReader("front 1"), strings.NewReader("front 2")}
+	rear := []io.ReadSeeker{strings.NewReader("rear 2"), strings.NewReader("rear 1")}
+	pages, err := orderPages(front, rear, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []io.ReadSeeker{front[0], rear[1], front[1], rear[0]}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages are not interleaved with the reversed rear pass")
+	}
+}
+
+func TestRotatePages(t *testing.T) {
+	pages := []io.ReadSeeker{}
+	for i := 0; i < 2; i++ {
+		page, err := textToPdf(strings.NewReader("rear"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, page)
+	}
+	rotated, err := rotatePages(pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != len(pages) {
+		t.Fatalf("%d pages rotated, want %d", len(rotated), len(pages))
+	}
+	for i, page := range rotated {
+		ctx, err := api.ReadAndValidate(page, model.NewDefaultConfiguration())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, inherited, err := ctx.PageDict(1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inherited.Rotate != 180 {
+			t.Errorf("page %d rotated by %d, want 180", i+1, inherited.Rotate)
+		}
+	}
+}